@@ -11,6 +11,7 @@ import (
 type Node interface {
 	TokenLiteral() string // only used for debugging and testing
 	String() string
+	Pos() token.Position // position of the node in the source code
 }
 
 // Statement represents the let/if/return statements
@@ -40,6 +41,14 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+// Pos returns the position of the first statement
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 // String returns all the program statements as an string
 func (p *Program) String() string {
 	var out bytes.Buffer
@@ -58,6 +67,7 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
 
 // String returns a string with the let statement like `let x = 5;`
 func (ls *LetStatement) String() string {
@@ -74,6 +84,33 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// AssignStatement updates an existing binding or an element of an array or
+// hash, like `x = 5;`, `x += 1;` or `arr[0] = 1;`
+type AssignStatement struct {
+	Token    token.Token // the assignment operator token
+	Target   Expression  // *Identifier or *IndexExpression
+	Operator string      // "=", "+=", "-=", "*=" or "/="
+	Value    Expression
+}
+
+func (as *AssignStatement) statementNode()       {}
+func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
+
+// Pos returns the position of the target, where the statement starts
+func (as *AssignStatement) Pos() token.Position { return as.Target.Pos() }
+
+// String returns a string with the assignment like `x += 5;`
+func (as *AssignStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(as.Target.String())
+	out.WriteString(" " + as.Operator + " ")
+	if as.Value != nil {
+		out.WriteString(as.Value.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
 // Identifier implements the expression interface so that it can also accept expressions
 // like `let x = 5 + 5` not just `let x = 10`.
 type Identifier struct {
@@ -83,6 +120,7 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 func (i *Identifier) String() string       { return i.Value }
 
 // ReturnStatement stores the Value returned
@@ -93,6 +131,7 @@ type ReturnStatement struct {
 
 func (ls *ReturnStatement) statementNode()       {}
 func (ls *ReturnStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *ReturnStatement) Pos() token.Position  { return ls.Token.Pos }
 
 // String returns a string with the return statement like `return 5;`
 func (rs *ReturnStatement) String() string {
@@ -105,6 +144,25 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement raises an error with the Value, like `throw "bad input";`
+type ThrowStatement struct {
+	Token token.Token // the 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(ts.TokenLiteral() + " ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
 // ExpressionStatement stores an expression
 type ExpressionStatement struct {
 	Token      token.Token // the first token of the expression
@@ -113,6 +171,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 
 // String returns a string with the expression
 func (es *ExpressionStatement) String() string {
@@ -130,8 +189,20 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+// FloatLiteral is a floating-point number expression like `1.5;`
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 // PrefixExpression is just an expression like `-5;`
 type PrefixExpression struct {
 	Token    token.Token // the prefix token, e.g. !
@@ -141,6 +212,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -162,6 +234,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -182,6 +255,7 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 // IfExpression represents an expression with 2 parts. Each part contains a BlockStatement,
@@ -195,6 +269,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -208,6 +283,101 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// WhileExpression represents a loop that runs the body while the condition is
+// truthy. Like if, it's an expression, and it always produces null.
+type WhileExpression struct {
+	Token     token.Token // the 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (we *WhileExpression) expressionNode()      {}
+func (we *WhileExpression) TokenLiteral() string { return we.Token.Literal }
+func (we *WhileExpression) Pos() token.Position  { return we.Token.Pos }
+func (we *WhileExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("while")
+	out.WriteString(we.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(we.Body.String())
+	return out.String()
+}
+
+// ForExpression represents a loop that runs the body for each element of an
+// iterable, like `for (x in [1, 2, 3]) { puts(x) }`. It always produces null.
+type ForExpression struct {
+	Token    token.Token // the 'for' token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fe *ForExpression) expressionNode()      {}
+func (fe *ForExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForExpression) Pos() token.Position  { return fe.Token.Pos }
+func (fe *ForExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("for(")
+	out.WriteString(fe.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fe.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fe.Body.String())
+	return out.String()
+}
+
+// TryExpression runs the Block and, if it raises an error, the Catch block with
+// the error bound to CatchVariable. The Finally block always runs at the end.
+// At least one of Catch and Finally is present. Like if, it's an expression,
+// which produces the value of the Block or the Catch block.
+type TryExpression struct {
+	Token         token.Token // the 'try' token
+	Block         *BlockStatement
+	CatchVariable *Identifier // nil if there is no catch
+	Catch         *BlockStatement
+	Finally       *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+	if te.Catch != nil {
+		out.WriteString(" catch(")
+		out.WriteString(te.CatchVariable.String())
+		out.WriteString(") ")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+	return out.String()
+}
+
+// BreakStatement stops the innermost loop
+type BreakStatement struct {
+	Token token.Token // the 'break' token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) String() string       { return bs.TokenLiteral() + ";" }
+
+// ContinueStatement skips to the next iteration of the innermost loop
+type ContinueStatement struct {
+	Token token.Token // the 'continue' token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) String() string       { return cs.TokenLiteral() + ";" }
+
 // BlockStatement represents a piece of code containing statements
 type BlockStatement struct {
 	Token      token.Token // the { token
@@ -216,6 +386,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -224,24 +395,50 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
-// FunctionLiteral is the definition of a function
+// FunctionLiteral is the definition of a function, like `fn(a, b = 10, ...rest) { ... }`
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
 	Parameters []*Identifier
-	Body       *BlockStatement
+	// Defaults has the default value of each parameter, or nil if it has none.
+	// It's empty if no parameter has a default value.
+	Defaults []Expression
+	Rest     *Identifier // the parameter collecting the extra arguments, if any
+	Body     *BlockStatement
+	Name     string // the name the function is bound to with `let`, if any
+}
+
+// Default returns the default value of the i-th parameter, or nil if it's required
+func (fl *FunctionLiteral) Default(i int) Expression {
+	if i < len(fl.Defaults) {
+		return fl.Defaults[i]
+	}
+	return nil
+}
+
+// ParametersString returns the list of parameters, like `a, b = 10, ...rest`
+func (fl *FunctionLiteral) ParametersString() string {
+	params := []string{}
+	for i, p := range fl.Parameters {
+		if def := fl.Default(i); def != nil {
+			params = append(params, p.String()+" = "+def.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
+	}
+	return strings.Join(params, ", ")
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
-	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
-	}
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(fl.ParametersString())
 	out.WriteString(") ")
 	out.WriteString(fl.Body.String())
 	return out.String()
@@ -256,6 +453,7 @@ type CallExpression struct {
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Token.Pos }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -276,6 +474,7 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 type ArrayLiteral struct {
@@ -285,6 +484,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -306,6 +506,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -323,6 +524,7 @@ type HashLiteral struct {
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
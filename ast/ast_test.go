@@ -1,6 +1,7 @@
 package ast
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/juandspy/monkey-lang/token"
@@ -26,3 +27,59 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestWalk(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+	// x = fn(a) { a + y };
+	program := &Program{
+		Statements: []Statement{
+			&AssignStatement{
+				Token:    token.Token{Type: token.ASSIGN, Literal: "="},
+				Target:   ident("x"),
+				Operator: "=",
+				Value: &FunctionLiteral{
+					Token:      token.Token{Type: token.FUNCTION, Literal: "fn"},
+					Parameters: []*Identifier{ident("a")},
+					Body: &BlockStatement{
+						Statements: []Statement{
+							&ExpressionStatement{
+								Expression: &InfixExpression{
+									Token:    token.Token{Type: token.PLUS, Literal: "+"},
+									Left:     ident("a"),
+									Operator: "+",
+									Right:    ident("y"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if program.String() != "x = fn(a) (a + y);" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+
+	names := func(prune bool) []string {
+		visited := []string{}
+		Walk(program, func(n Node) bool {
+			if ident, ok := n.(*Identifier); ok {
+				visited = append(visited, ident.Value)
+			}
+			_, isFunction := n.(*FunctionLiteral)
+			return !(prune && isFunction)
+		})
+		return visited
+	}
+
+	expected := []string{"x", "a", "a", "y"}
+	if got := names(false); strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("wrong identifiers visited. want=%v, got=%v", expected, got)
+	}
+	expected = []string{"x"}
+	if got := names(true); strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("wrong identifiers visited when skipping functions. want=%v, got=%v", expected, got)
+	}
+}
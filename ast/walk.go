@@ -0,0 +1,103 @@
+package ast
+
+import "sort"
+
+// Walk traverses the AST in depth-first order. It calls fn for each node, and
+// the children of the node are visited only if fn returns true.
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		for _, s := range node.Statements {
+			Walk(s, fn)
+		}
+	case *BlockStatement:
+		for _, s := range node.Statements {
+			Walk(s, fn)
+		}
+	case *LetStatement:
+		Walk(node.Name, fn)
+		walkExpression(node.Value, fn)
+	case *AssignStatement:
+		walkExpression(node.Target, fn)
+		walkExpression(node.Value, fn)
+	case *ReturnStatement:
+		walkExpression(node.ReturnValue, fn)
+	case *ThrowStatement:
+		walkExpression(node.Value, fn)
+	case *ExpressionStatement:
+		walkExpression(node.Expression, fn)
+	case *PrefixExpression:
+		walkExpression(node.Right, fn)
+	case *InfixExpression:
+		walkExpression(node.Left, fn)
+		walkExpression(node.Right, fn)
+	case *IfExpression:
+		walkExpression(node.Condition, fn)
+		Walk(node.Consequence, fn)
+		if node.Alternative != nil {
+			Walk(node.Alternative, fn)
+		}
+	case *WhileExpression:
+		walkExpression(node.Condition, fn)
+		Walk(node.Body, fn)
+	case *ForExpression:
+		Walk(node.Variable, fn)
+		walkExpression(node.Iterable, fn)
+		Walk(node.Body, fn)
+	case *TryExpression:
+		Walk(node.Block, fn)
+		if node.Catch != nil {
+			Walk(node.CatchVariable, fn)
+			Walk(node.Catch, fn)
+		}
+		if node.Finally != nil {
+			Walk(node.Finally, fn)
+		}
+	case *FunctionLiteral:
+		for i, p := range node.Parameters {
+			Walk(p, fn)
+			walkExpression(node.Default(i), fn)
+		}
+		if node.Rest != nil {
+			Walk(node.Rest, fn)
+		}
+		Walk(node.Body, fn)
+	case *CallExpression:
+		walkExpression(node.Function, fn)
+		for _, a := range node.Arguments {
+			walkExpression(a, fn)
+		}
+	case *ArrayLiteral:
+		for _, e := range node.Elements {
+			walkExpression(e, fn)
+		}
+	case *IndexExpression:
+		walkExpression(node.Left, fn)
+		walkExpression(node.Index, fn)
+	case *HashLiteral:
+		keys := []Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		// sort the keys so that the nodes are always visited in the same order
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Pos().Offset < keys[j].Pos().Offset
+		})
+		for _, k := range keys {
+			walkExpression(k, fn)
+			walkExpression(node.Pairs[k], fn)
+		}
+	}
+}
+
+// walkExpression walks the expression unless it's missing, which happens in
+// the nodes with optional parts or built from broken code
+func walkExpression(exp Expression, fn func(Node) bool) {
+	if exp != nil {
+		Walk(exp, fn)
+	}
+}
@@ -0,0 +1,269 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Instructions is a flat sequence of bytes containing opcodes and their operands
+type Instructions []byte
+
+// String returns a human readable version of the instructions, one per line, like
+// `0000 OpConstant 1`
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		i += 1 + read
+	}
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+// Opcode is the first byte of every instruction and tells the VM what to do
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota // push a constant from the constant pool
+
+	OpPop // pop the topmost element of the stack
+
+	// Infix operators, they pop two elements and push the result
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpGreaterEqual
+	OpLessEqual
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+
+	// Prefix operators, they pop one element and push the result
+	OpMinus
+	OpBang
+	OpBitNot
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	// Jumps, their operand is the absolute offset to jump to
+	OpJumpNotTruthy
+	OpJump
+
+	// Bindings
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpGetFree
+	OpCurrentClosure
+
+	// Data structures, their operand is the number of elements in the stack
+	OpArray
+	OpHash
+	OpIndex
+
+	// Functions
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpClosure
+	OpSkipDefault // jump if the argument of a parameter was passed, skipping its default value
+
+	// Loops
+	OpIter     // pop an iterable and push an iterator over it
+	OpIterNext // push the next element of the iterator, or pop it and jump to the operand
+
+	// Assignments
+	OpSetIndex    // pop a value, an index and a collection and assign the element
+	OpUpdateIndex // like OpSetIndex, applying the operator in the operand to the element and the value
+	OpNewCell     // pop a value and push a cell holding it
+	OpLoadCell    // pop a cell and push its value
+	OpStoreCell   // pop a cell and a value and store the value in the cell
+
+	// Errors
+	OpTry    // register a handler that jumps to the operand if an error is raised
+	OpEndTry // remove the innermost handler
+	OpCatch  // pop the error pushed by the handler and push the value the catch block binds
+	OpThrow  // pop a value and raise it as an error
+)
+
+// Definition describes an opcode: its name and the number of bytes each operand takes
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+
+	OpPop: {"OpPop", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpPow:          {"OpPow", []int{}},
+	OpBitAnd:       {"OpBitAnd", []int{}},
+	OpBitOr:        {"OpBitOr", []int{}},
+	OpBitXor:       {"OpBitXor", []int{}},
+	OpShiftLeft:    {"OpShiftLeft", []int{}},
+	OpShiftRight:   {"OpShiftRight", []int{}},
+
+	OpMinus:  {"OpMinus", []int{}},
+	OpBang:   {"OpBang", []int{}},
+	OpBitNot: {"OpBitNot", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJump:          {"OpJump", []int{2}},
+
+	OpGetGlobal:      {"OpGetGlobal", []int{2}},
+	OpSetGlobal:      {"OpSetGlobal", []int{2}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{2}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	OpArray: {"OpArray", []int{2}},
+	OpHash:  {"OpHash", []int{2}},
+	OpIndex: {"OpIndex", []int{}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	// the constant index of the function and the number of free variables
+	OpClosure: {"OpClosure", []int{2, 1}},
+	// the index of the parameter and the offset to jump to
+	OpSkipDefault: {"OpSkipDefault", []int{1, 2}},
+
+	OpIter:     {"OpIter", []int{}},
+	OpIterNext: {"OpIterNext", []int{2}},
+
+	// the operand of OpUpdateIndex is the opcode of the operator of a compound
+	// assignment like `arr[0] += 1`
+	OpSetIndex:    {"OpSetIndex", []int{}},
+	OpUpdateIndex: {"OpUpdateIndex", []int{1}},
+	OpNewCell:     {"OpNewCell", []int{}},
+	OpLoadCell:    {"OpLoadCell", []int{}},
+	OpStoreCell:   {"OpStoreCell", []int{}},
+
+	OpTry:    {"OpTry", []int{2}},
+	OpEndTry: {"OpEndTry", []int{}},
+	OpCatch:  {"OpCatch", []int{}},
+	OpThrow:  {"OpThrow", []int{}},
+}
+
+// Lookup returns the definition of the given opcode
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make encodes an opcode and its operands (in big endian) into a single instruction
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+	return instruction
+}
+
+// ReadOperands is the opposite of Make. It decodes the operands of an instruction
+// and returns them along with the number of bytes read
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+	return operands, offset
+}
+
+// ReadUint16 decodes a two bytes operand
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+// ReadUint8 decodes a one byte operand
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
@@ -0,0 +1,1119 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/juandspy/monkey-lang/ast"
+	"github.com/juandspy/monkey-lang/code"
+	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/token"
+)
+
+// EmittedInstruction is used to remember the last instructions emitted, so that
+// they can be removed or replaced
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// CompilationScope holds the instructions of the function being compiled
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+	positions           map[int]token.Position // instruction offset -> source position
+	loops               []*loop                // loops enclosing the current instruction
+	tries               []*tryBlock            // try blocks whose handler is active at the current instruction
+	operands            int                    // values of the enclosing expressions in the stack, see compileOperands
+}
+
+// loop holds what `break` and `continue` need to jump out of the loop being compiled
+type loop struct {
+	start       int   // offset continue jumps to
+	breaks      []int // offsets of the break jumps, patched when the loop ends
+	hasIterator bool  // break must pop the iterator of a for loop
+	operands    int   // values in the stack when the loop starts
+}
+
+// tryBlock holds what leaving a try block with return, break or continue needs
+type tryBlock struct {
+	finally *ast.BlockStatement // run before leaving, if any
+	loops   int                 // number of loops enclosing the try block
+}
+
+// Compiler turns an AST into bytecode that can be executed by the VM
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+	builtins    []object.Object // the globals of the builtins registry, by index
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	pos token.Position // position of the node being compiled
+
+	// operandErr is the first operand that didn't fit in its instruction,
+	// returned by Compile as emit can't fail
+	operandErr *object.Error
+}
+
+// New returns a Compiler with an empty state and the standard builtins
+func New() *Compiler {
+	return NewWithBuiltins(object.NewRegistry())
+}
+
+// NewWithBuiltins returns a Compiler with an empty state and the builtins of
+// the registry
+func NewWithBuiltins(builtins *object.Registry) *Compiler {
+	mainScope := CompilationScope{
+		instructions: code.Instructions{},
+		positions:    make(map[int]token.Position),
+	}
+
+	symbolTable := NewSymbolTable()
+	DefineBuiltins(symbolTable, builtins)
+
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		builtins:    builtins.Globals(),
+		scopes:      []CompilationScope{mainScope},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState returns a Compiler that keeps the symbols and constants of a
+// previous compilation, which is what the REPL needs between lines. The
+// symbols of the builtins must be defined from the same registry.
+func NewWithState(s *SymbolTable, constants []object.Object, builtins *object.Registry) *Compiler {
+	compiler := NewWithBuiltins(builtins)
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+// DefineBuiltins defines the symbols of the builtins and namespaces of the
+// registry in the symbol table
+func DefineBuiltins(s *SymbolTable, builtins *object.Registry) {
+	for i, name := range builtins.Names() {
+		if name != "" {
+			s.DefineBuiltin(i, name)
+		}
+	}
+}
+
+// Compile walks the AST recursively emitting the instructions for each node.
+// Compilation errors are returned as *object.Error, just like the evaluator does.
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	defer func() {
+		if err == nil && c.operandErr != nil {
+			err = c.operandErr
+		}
+	}()
+	if pos := node.Pos(); pos.IsValid() {
+		previous := c.pos
+		c.pos = pos
+		defer func() { c.pos = previous }()
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		c.declareGlobals(node)
+		for _, s := range node.Statements {
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+	case *ast.ExpressionStatement:
+		err := c.Compile(node.Expression)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+	case *ast.LetStatement:
+		// a function that assigns its own name refers to this binding, which
+		// must exist when its body is compiled
+		if fn, ok := node.Value.(*ast.FunctionLiteral); ok && assignsName(fn) {
+			c.symbolTable.Define(node.Name.Value)
+		}
+		// the value is compiled first so that `let x = x + 1` refers to the previous x
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		symbol := c.symbolTable.Define(node.Name.Value)
+		c.storeSymbol(symbol)
+	case *ast.AssignStatement:
+		return c.compileAssignStatement(node)
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+		err = c.leaveTries(0)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+		err := c.compileOperands(node.Left, node.Right)
+		if err != nil {
+			return err
+		}
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return c.newError("unknown operator %s", node.Operator)
+		}
+		c.emit(op)
+	case *ast.PrefixExpression:
+		err := c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		case "~":
+			c.emit(code.OpBitNot)
+		default:
+			return c.newError("unknown operator %s", node.Operator)
+		}
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+	case *ast.WhileExpression:
+		return c.compileWhileExpression(node)
+	case *ast.ForExpression:
+		return c.compileForExpression(node)
+	case *ast.TryExpression:
+		return c.compileTryExpression(node)
+	case *ast.BreakStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return c.newError("break is not in a loop")
+		}
+		err := c.leaveTries(c.loopTries())
+		if err != nil {
+			return err
+		}
+		c.popOperands(l)
+		if l.hasIterator {
+			c.emit(code.OpPop)
+		}
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+	case *ast.ContinueStatement:
+		l := c.currentLoop()
+		if l == nil {
+			return c.newError("continue is not in a loop")
+		}
+		err := c.leaveTries(c.loopTries())
+		if err != nil {
+			return err
+		}
+		c.popOperands(l)
+		c.emit(code.OpJump, l.start)
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return c.newError("identifier not found: %s", node.Value)
+		}
+		c.loadSymbol(symbol)
+		if symbol.Cell {
+			c.emit(code.OpLoadCell)
+		}
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+	case *ast.ArrayLiteral:
+		elements := []ast.Node{}
+		for _, el := range node.Elements {
+			elements = append(elements, el)
+		}
+		err := c.compileOperands(elements...)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		// sort the keys so that the emitted instructions are always the same
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		pairs := []ast.Node{}
+		for _, k := range keys {
+			pairs = append(pairs, k, node.Pairs[k])
+		}
+		err := c.compileOperands(pairs...)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+	case *ast.IndexExpression:
+		err := c.compileOperands(node.Left, node.Index)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+	case *ast.CallExpression:
+		operands := []ast.Node{node.Function}
+		for _, a := range node.Arguments {
+			operands = append(operands, a)
+		}
+		err := c.compileOperands(operands...)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpCall, len(node.Arguments))
+	}
+	return nil
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+	">=": code.OpGreaterEqual,
+	"<=": code.OpLessEqual,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShiftLeft,
+	">>": code.OpShiftRight,
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+	// the jump offset is patched once we know the size of the consequence
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	err = c.compileBlockValue(node.Consequence)
+	if err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else {
+		err := c.compileBlockValue(node.Alternative)
+		if err != nil {
+			return err
+		}
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileLogicalExpression emits the following for `&&`, where `!!` converts the
+// right side to a boolean:
+//
+//	        <left>
+//	        OpJumpNotTruthy false
+//	        <right>
+//	        OpBang
+//	        OpBang
+//	        OpJump end
+//	false:  OpFalse
+//	end:
+//
+// and the following for `||`:
+//
+//	        <left>
+//	        OpJumpNotTruthy right
+//	        OpTrue
+//	        OpJump end
+//	right:  <right>
+//	        OpBang
+//	        OpBang
+//	end:
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "||" {
+		c.emit(code.OpTrue)
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		err = c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpBang)
+		c.emit(code.OpBang)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.emit(code.OpFalse)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileWhileExpression emits:
+//
+//	start: <condition>
+//	       OpJumpNotTruthy end
+//	       <body>
+//	       OpJump start
+//	end:   OpNull
+func (c *Compiler) compileWhileExpression(node *ast.WhileExpression) error {
+	start := len(c.currentInstructions())
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	l, err := c.compileLoopBody(node.Body, start, false)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	end := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, end)
+	c.patchBreaks(l, end)
+	c.emit(code.OpNull)
+	return nil
+}
+
+// compileForExpression emits the following, keeping the iterator in the stack
+// during the loop:
+//
+//	       <iterable>
+//	       OpIter
+//	start: OpIterNext end
+//	       OpSetGlobal/OpSetLocal <variable>
+//	       <body>
+//	       OpJump start
+//	end:   OpNull
+func (c *Compiler) compileForExpression(node *ast.ForExpression) error {
+	err := c.Compile(node.Iterable)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+
+	start := c.emit(code.OpIterNext, 9999)
+	symbol := c.symbolTable.Define(node.Variable.Value)
+	c.storeSymbol(symbol)
+
+	l, err := c.compileLoopBody(node.Body, start, true)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	end := len(c.currentInstructions())
+	c.changeOperand(start, end)
+	c.patchBreaks(l, end)
+	c.emit(code.OpNull)
+	return nil
+}
+
+// compileLoopBody compiles the body of a loop, whose continue statements jump to start
+func (c *Compiler) compileLoopBody(body *ast.BlockStatement, start int, hasIterator bool) (*loop, error) {
+	l := &loop{start: start, hasIterator: hasIterator, operands: c.scopes[c.scopeIndex].operands}
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, l)
+	err := c.Compile(body)
+	scope = &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	return l, err
+}
+
+// compileOperands compiles the nodes in order. The values of the ones already
+// compiled stay in the stack while the next ones are compiled, so a break or
+// continue inside them must pop those values, like in `[x, if (x > 1) { break }]`.
+func (c *Compiler) compileOperands(nodes ...ast.Node) error {
+	operands := c.scopes[c.scopeIndex].operands
+	defer func() { c.scopes[c.scopeIndex].operands = operands }()
+	for i, n := range nodes {
+		c.scopes[c.scopeIndex].operands = operands + i
+		if err := c.Compile(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// popOperands emits the pops of the values of the expressions that a break or
+// continue of the loop leaves unfinished
+func (c *Compiler) popOperands(l *loop) {
+	for i := l.operands; i < c.scopes[c.scopeIndex].operands; i++ {
+		c.emit(code.OpPop)
+	}
+}
+
+// patchBreaks makes the break statements of the loop jump to the given offset
+func (c *Compiler) patchBreaks(l *loop, offset int) {
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, offset)
+	}
+}
+
+// currentLoop returns the innermost loop of the function being compiled, if any
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// compileTryExpression emits the following, where the handler registered by
+// OpTry pushes the error and jumps to its operand:
+//
+//	         OpTry catch
+//	         <block>
+//	         OpEndTry
+//	         OpJump end
+//	catch:   OpTry rethrow
+//	         OpCatch
+//	         OpSetGlobal/OpSetLocal <catch variable>
+//	         <catch block>
+//	         OpEndTry
+//	         OpJump end
+//	rethrow: <finally block>
+//	         OpThrow
+//	end:     <finally block>
+//
+// Without a finally block, the catch block isn't protected by a handler and
+// there is no rethrow. Without a catch block, the first handler jumps straight
+// to rethrow. In both cases the value of the try or the catch block is left in
+// the stack.
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	tryPos := c.emit(code.OpTry, 9999)
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, &tryBlock{finally: node.Finally, loops: len(scope.loops)})
+	err := c.compileBlockValue(node.Block)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpEndTry)
+	jumps := []int{c.emit(code.OpJump, 9999)}
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+
+	if node.Catch != nil {
+		catchTryPos := -1
+		if node.Finally != nil {
+			catchTryPos = c.emit(code.OpTry, 9999)
+		} else {
+			c.popTry()
+		}
+		c.emit(code.OpCatch)
+		symbol := c.symbolTable.Define(node.CatchVariable.Value)
+		c.storeSymbol(symbol)
+		err := c.compileBlockValue(node.Catch)
+		if err != nil {
+			return err
+		}
+		if catchTryPos >= 0 {
+			c.emit(code.OpEndTry)
+			jumps = append(jumps, c.emit(code.OpJump, 9999))
+			c.changeOperand(catchTryPos, len(c.currentInstructions()))
+		}
+	}
+
+	if node.Finally != nil {
+		c.popTry()
+		// the error raised by the try or the catch block is in the stack
+		err := c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+	for _, pos := range jumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	if node.Finally != nil {
+		return c.Compile(node.Finally)
+	}
+	return nil
+}
+
+// popTry removes the innermost try block of the function being compiled
+func (c *Compiler) popTry() {
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+}
+
+// loopTries returns the index of the first try block inside the innermost loop,
+// which are the ones break and continue leave
+func (c *Compiler) loopTries() int {
+	scope := c.scopes[c.scopeIndex]
+	i := len(scope.tries)
+	for i > 0 && scope.tries[i-1].loops >= len(scope.loops) {
+		i--
+	}
+	return i
+}
+
+// leaveTries emits what jumping out of the try blocks of the function from the
+// given index needs, the innermost first: OpEndTry to remove the handler and
+// the finally block, if any
+func (c *Compiler) leaveTries(from int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	for i := len(tries) - 1; i >= from; i-- {
+		c.emit(code.OpEndTry)
+		if tries[i].finally == nil {
+			continue
+		}
+		// the finally block is outside the try block, so a return inside it
+		// must not run it again
+		c.scopes[c.scopeIndex].tries = tries[:i:i]
+		err := c.Compile(tries[i].finally)
+		c.scopes[c.scopeIndex].tries = tries
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileBlockValue compiles a block whose last value must stay in the stack,
+// like the branches of an if expression. Blocks that don't end with an
+// expression produce null.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	// a function that assigns its own name uses the binding of the let, like
+	// the evaluator, instead of the current closure
+	if node.Name != "" && !assignsName(node) {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+	if node.Rest != nil {
+		c.symbolTable.Define(node.Rest.Value)
+	}
+	numDefaults, err := c.compileDefaults(node)
+	if err != nil {
+		return err
+	}
+	c.defineCells(node)
+
+	err = c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+
+	// the last expression of the body is the implicit return value
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	positions := c.scopes[c.scopeIndex].positions
+	instructions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.loadSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Instructions:  instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		NumDefaults:   numDefaults,
+		Variadic:      node.Rest != nil,
+		Name:          node.Name,
+		Positions:     positions,
+		Literal:       node,
+	}
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+	return nil
+}
+
+// compileDefaults emits the code that sets the default value of the parameters
+// whose argument is missing, at the beginning of the function:
+//
+//	       OpSkipDefault <parameter> next
+//	       <default value>
+//	       OpSetLocal <parameter>
+//	next:  ...
+func (c *Compiler) compileDefaults(node *ast.FunctionLiteral) (int, error) {
+	numDefaults := 0
+	for i := range node.Parameters {
+		def := node.Default(i)
+		if def == nil {
+			continue
+		}
+		numDefaults++
+		skipPos := c.emit(code.OpSkipDefault, i, 9999)
+		if err := c.Compile(def); err != nil {
+			return 0, err
+		}
+		c.emit(code.OpSetLocal, i)
+		c.replaceInstruction(skipPos, c.make(code.OpSkipDefault, i, len(c.currentInstructions())))
+	}
+	return numDefaults, nil
+}
+
+// compileAssignStatement emits the instructions to update a variable, or an
+// element of an array or hash
+func (c *Compiler) compileAssignStatement(node *ast.AssignStatement) error {
+	compound := node.Operator != "="
+	op := infixOperators[strings.TrimSuffix(node.Operator, "=")]
+
+	if target, ok := node.Target.(*ast.IndexExpression); ok {
+		if err := c.compileOperands(target.Left, target.Index, node.Value); err != nil {
+			return err
+		}
+		if compound {
+			c.emit(code.OpUpdateIndex, int(op))
+		} else {
+			c.emit(code.OpSetIndex)
+		}
+		return nil
+	}
+
+	name := node.Target.(*ast.Identifier).Value
+	symbol, ok := c.symbolTable.Resolve(name)
+	if !ok || symbol.Scope == BuiltinScope {
+		return c.newError("assignment to undeclared identifier: %s", name)
+	}
+	if symbol.Scope == FunctionScope || (symbol.Scope == FreeScope && !symbol.Cell) {
+		return c.newError("cannot assign to %s from its own function", name)
+	}
+	if compound {
+		c.loadSymbol(symbol)
+		if symbol.Cell {
+			c.emit(code.OpLoadCell)
+		}
+		c.scopes[c.scopeIndex].operands++
+		defer func() { c.scopes[c.scopeIndex].operands-- }()
+	}
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	if compound {
+		c.emit(op)
+	}
+	c.storeSymbol(symbol)
+	return nil
+}
+
+// storeSymbol emits the instructions to bind the value on top of the stack to
+// a global or local symbol
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch {
+	case s.Cell:
+		c.loadSymbol(s)
+		c.emit(code.OpStoreCell)
+	case s.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	default:
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// defineCells defines the locals of the function that are captured by closures
+// and can change after the capture, and emits the instructions to store them in
+// cells. As closures get the cells instead of copies of the values, the changes
+// made by the function and the closures are visible to each other, like in the
+// evaluator where they share the environment.
+func (c *Compiler) defineCells(node *ast.FunctionLiteral) {
+	captured := map[string]bool{}
+	assigned := map[string]bool{}
+	ast.Walk(node.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			ast.Walk(n, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Identifier); ok {
+					captured[ident.Value] = true
+				}
+				return true
+			})
+		case *ast.AssignStatement:
+			if ident, ok := n.Target.(*ast.Identifier); ok {
+				assigned[ident.Value] = true
+			}
+		}
+		return true
+	})
+
+	// locals defined by the function itself, not by the closures inside it.
+	// Those defined more than once or inside loops can change too.
+	locals := []string{}
+	lets := map[string]int{}
+	var collect func(n ast.Node, inLoop bool) bool
+	collect = func(n ast.Node, inLoop bool) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			locals = append(locals, n.Name.Value)
+			lets[n.Name.Value]++
+			if inLoop {
+				assigned[n.Name.Value] = true
+			}
+		case *ast.TryExpression:
+			if n.CatchVariable != nil {
+				locals = append(locals, n.CatchVariable.Value)
+				lets[n.CatchVariable.Value]++
+				if inLoop {
+					assigned[n.CatchVariable.Value] = true
+				}
+			}
+		case *ast.ForExpression, *ast.WhileExpression:
+			if f, ok := n.(*ast.ForExpression); ok {
+				locals = append(locals, f.Variable.Value)
+				assigned[f.Variable.Value] = true
+			}
+			if !inLoop {
+				ast.Walk(n, func(n ast.Node) bool { return collect(n, true) })
+				return false
+			}
+		}
+		return true
+	}
+	ast.Walk(node.Body, func(n ast.Node) bool { return collect(n, false) })
+	for name, count := range lets {
+		if count > 1 {
+			assigned[name] = true
+		}
+	}
+
+	params := node.Parameters
+	if node.Rest != nil {
+		params = append(params[:len(params):len(params)], node.Rest)
+	}
+	for _, p := range params {
+		if captured[p.Value] && assigned[p.Value] {
+			symbol := c.symbolTable.DefineCell(p.Value)
+			c.emit(code.OpGetLocal, symbol.Index)
+			c.emit(code.OpNewCell)
+			c.emit(code.OpSetLocal, symbol.Index)
+		}
+	}
+	for _, name := range locals {
+		if !captured[name] || !assigned[name] {
+			continue
+		}
+		if symbol, ok := c.symbolTable.store[name]; ok && symbol.Cell {
+			// a parameter or a local already defined
+			continue
+		}
+		symbol := c.symbolTable.DefineCell(name)
+		c.emit(code.OpNull)
+		c.emit(code.OpNewCell)
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+}
+
+// assignsName returns true if the function, or a closure inside it, assigns
+// the name of the function
+func assignsName(node *ast.FunctionLiteral) bool {
+	found := false
+	ast.Walk(node.Body, func(n ast.Node) bool {
+		if assign, ok := n.(*ast.AssignStatement); ok {
+			if ident, ok := assign.Target.(*ast.Identifier); ok && ident.Value == node.Name {
+				found = true
+			}
+		}
+		return !found
+	})
+	return node.Name != "" && found
+}
+
+// declareGlobals defines the global bindings of the program before compiling
+// it, so that the functions can refer to the ones defined after them, like in
+// the evaluator where the names are looked up when the function runs. The VM
+// reports the globals read before being set as not found. The names that are
+// already defined, like the builtins, are left as they are, so that
+// `let len = len(x)` still calls the builtin.
+func (c *Compiler) declareGlobals(program *ast.Program) {
+	ast.Walk(program, func(n ast.Node) bool {
+		var name *ast.Identifier
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			name = n.Name
+		case *ast.ForExpression:
+			name = n.Variable
+		case *ast.TryExpression:
+			name = n.CatchVariable
+		}
+		if name != nil {
+			if _, ok := c.symbolTable.Resolve(name.Value); !ok {
+				c.symbolTable.Define(name.Value)
+			}
+		}
+		return true
+	})
+}
+
+// Bytecode contains everything the VM needs to run the compiled program
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Positions    map[int]token.Position
+	Builtins     []object.Object // the builtins and namespaces, by the index of OpGetBuiltin
+	Globals      []string        // the names of the globals, by the index of OpGetGlobal
+}
+
+// Bytecode returns the result of the compilation
+func (c *Compiler) Bytecode() *Bytecode {
+	globals := make([]string, c.symbolTable.numDefinitions)
+	for _, symbol := range c.symbolTable.Symbols() {
+		if symbol.Scope == GlobalScope {
+			globals[symbol.Index] = symbol.Name
+		}
+	}
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Builtins:     c.builtins,
+		Globals:      globals,
+	}
+}
+
+// SymbolTable returns the symbol table of the global scope, so that it can be
+// reused in a following compilation with NewWithState
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// emit adds an instruction to the current scope and returns its position
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := c.make(op, operands...)
+	pos := c.addInstruction(ins)
+	c.setLastInstruction(op, pos)
+	if c.pos.IsValid() {
+		c.scopes[c.scopeIndex].positions[pos] = c.pos
+	}
+	return pos
+}
+
+// make encodes the instruction like code.Make, keeping the error of the first
+// operand that doesn't fit in its width, which code.Make would cut off
+func (c *Compiler) make(op code.Opcode, operands ...int) []byte {
+	def, err := code.Lookup(byte(op))
+	if err == nil && c.operandErr == nil {
+		for i, o := range operands {
+			if o < 0 || o >= 1<<(8*def.OperandWidths[i]) {
+				c.operandErr = c.newError("%s: %d", operandLimit(op, i), o)
+				break
+			}
+		}
+	}
+	return code.Make(op, operands...)
+}
+
+// operandLimit describes what exceeds the width of the operand of an opcode
+func operandLimit(op code.Opcode, operand int) string {
+	switch op {
+	case code.OpConstant:
+		return "too many constants"
+	case code.OpClosure:
+		if operand == 0 {
+			return "too many constants"
+		}
+		return "too many free variables"
+	case code.OpGetFree:
+		return "too many free variables"
+	case code.OpGetLocal, code.OpSetLocal:
+		return "too many local bindings"
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return "too many global bindings"
+	case code.OpGetBuiltin:
+		return "too many builtins"
+	case code.OpCall:
+		return "too many arguments"
+	case code.OpArray:
+		return "too many elements in an array literal"
+	case code.OpHash:
+		return "too many pairs in a hash literal"
+	case code.OpSkipDefault:
+		if operand == 0 {
+			return "too many parameters"
+		}
+	}
+	// the operand is the offset of a jump
+	return "too many instructions to jump over"
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+	delete(c.scopes[c.scopeIndex].positions, last.Position)
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+// changeOperand replaces the operand of the instruction in the given position,
+// which is used to patch the jumps
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	newInstruction := c.make(op, operand)
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) enterScope() {
+	scope := CompilationScope{
+		instructions: code.Instructions{},
+		positions:    make(map[int]token.Position),
+	}
+	c.scopes = append(c.scopes, scope)
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return instructions
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// newError returns an *object.Error pointing to the node being compiled
+func (c *Compiler) newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Pos: c.pos}
+}
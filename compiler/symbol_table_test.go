@@ -0,0 +1,98 @@
+package compiler
+
+import "testing"
+
+func TestDefineAndResolve(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+	if a != (Symbol{Name: "a", Scope: GlobalScope, Index: 0}) {
+		t.Errorf("wrong symbol for a. got=%+v", a)
+	}
+	if again := global.Define("a"); again != a {
+		t.Errorf("redefining a should reuse its index. got=%+v", again)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	b := local.Define("b")
+	if b != (Symbol{Name: "b", Scope: LocalScope, Index: 0}) {
+		t.Errorf("wrong symbol for b. got=%+v", b)
+	}
+
+	nested := NewEnclosedSymbolTable(local)
+	nested.Define("c")
+
+	tests := []struct {
+		table    *SymbolTable
+		name     string
+		expected Symbol
+	}{
+		{global, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{local, "a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{local, "b", Symbol{Name: "b", Scope: LocalScope, Index: 0}},
+		{nested, "b", Symbol{Name: "b", Scope: FreeScope, Index: 0}},
+		{nested, "c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+	}
+	for _, tt := range tests {
+		result, ok := tt.table.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, result)
+		}
+	}
+
+	if len(nested.FreeSymbols) != 1 || nested.FreeSymbols[0] != b {
+		t.Errorf("wrong free symbols. got=%+v", nested.FreeSymbols)
+	}
+	if _, ok := global.Resolve("c"); ok {
+		t.Errorf("name c resolved in the global scope, but it shouldn't")
+	}
+}
+
+func TestDefineResolveBuiltinsAndFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	local := NewEnclosedSymbolTable(global)
+	local.DefineFunctionName("f")
+
+	expected := []Symbol{
+		{Name: "len", Scope: BuiltinScope, Index: 0},
+		{Name: "f", Scope: FunctionScope, Index: 0},
+	}
+	for _, sym := range expected {
+		result, ok := local.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("b")
+	global.DefineBuiltin(0, "len")
+	global.Define("a")
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 1},
+		{Name: "b", Scope: GlobalScope, Index: 0},
+		{Name: "len", Scope: BuiltinScope, Index: 0},
+	}
+	symbols := global.Symbols()
+	if len(symbols) != len(expected) {
+		t.Fatalf("wrong number of symbols. want=%d, got=%+v", len(expected), symbols)
+	}
+	for i, sym := range expected {
+		if symbols[i] != sym {
+			t.Errorf("symbol %d wrong. want=%+v, got=%+v", i, sym, symbols[i])
+		}
+	}
+}
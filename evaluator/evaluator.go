@@ -1,19 +1,69 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"math"
 
 	"github.com/juandspy/monkey-lang/ast"
 	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/token"
 )
 
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.LoopControl{Break: true}
+	CONTINUE = &object.LoopControl{Break: false}
 )
 
-func Eval(node ast.Node, env *object.Environment) object.Object {
+// MaxCallDepth is the maximum number of nested function calls, see
+// object.MaxCallDepth
+const MaxCallDepth = object.MaxCallDepth
+
+// EvalContext is like Eval, but the evaluation stops with an error of kind
+// object.LimitError when ctx is done or the limits are exceeded. It also
+// returns the resources used by the evaluation.
+func EvalContext(
+	ctx context.Context, node ast.Node, env *object.Environment, limits object.Limits,
+) (object.Object, object.Usage) {
+	execution, cancel := object.NewExecution(ctx, limits)
+	defer cancel()
+	previous := env.Execution()
+	execution.SetStreams(previous.Streams())
+	execution.SetRoots(func(m *object.Marker) { m.MarkEnvironment(env) })
+	env.SetExecution(execution)
+	defer env.SetExecution(previous)
+	return Eval(node, env), execution.Usage()
+}
+
+// Eval evaluates the given node. If the evaluation fails, the returned *object.Error
+// points to the innermost node that caused it. Go panics, which would be a bug in
+// the interpreter or in a builtin, are turned into errors too so that they don't
+// crash the host process.
+func Eval(node ast.Node, env *object.Environment) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+		if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() && node != nil {
+			err.Pos = node.Pos()
+		}
+	}()
+	execution := env.Execution()
+	if err := execution.Step(); err != nil {
+		return err
+	}
+	result = evalNode(node, env)
+	if result != nil {
+		execution.Temporaries = append(execution.Temporaries, result)
+	}
+	return result
+}
+
+func evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	// Statements
 	case *ast.Program:
@@ -23,83 +73,114 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isInterrupted(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isInterrupted(left) {
 			return left
 		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isInterrupted(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return allocate(evalInfixExpression(node.Operator, left, right), env.Execution())
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.WhileExpression:
+		return evalWhileExpression(node, env)
+	case *ast.ForExpression:
+		return evalForExpression(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isInterrupted(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isInterrupted(val) {
+			return val
+		}
+		return object.NewThrownError(val)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isInterrupted(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
+	case *ast.AssignStatement:
+		return evalAssignStatement(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 	case *ast.FunctionLiteral:
-		params := node.Parameters
-		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{
+			Name:       node.Name,
+			Parameters: node.Parameters,
+			Defaults:   node.Defaults,
+			Rest:       node.Rest,
+			Env:        env,
+			Body:       node.Body,
+		}
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isInterrupted(function) {
 			return function
 		}
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isInterrupted(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return applyFunction(function, args, env.Execution(), node.Pos())
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isInterrupted(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocate(&object.Array{Elements: elements}, env.Execution())
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isInterrupted(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isInterrupted(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return allocate(evalHashLiteral(node, env), env.Execution())
 	}
 	return nil
 }
 
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	release := holdTemporaries(env.Execution())
+	defer release()
 	var result object.Object
 	for _, statement := range program.Statements {
+		release()
 		result = Eval(statement, env)
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -112,22 +193,39 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 }
 
 // evalBlockStatement differs from evalProgram because it doesn't unwrap the return value
-// as it's done in the evalProgram function.
+// as it's done in the evalProgram function. A block without a value, like an
+// empty one or one ending in a let, evaluates to NULL.
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	release := holdTemporaries(env.Execution())
+	defer release()
 	var result object.Object
 	for _, statement := range block.Statements {
+		release()
 		result = Eval(statement, env)
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				// stop the loop if it finds a return or error
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+				rt == object.LOOP_CONTROL_OBJ {
+				// stop the loop if it finds a return, error, break or continue
 				return result
 			}
 		}
 	}
+	if result == nil {
+		return NULL
+	}
 	return result
 }
 
+// holdTemporaries returns a function that releases the values computed since
+// it was called, which are no longer in use once the statement computing them
+// ends. The values bound to names are still in use, but through the
+// environments.
+func holdTemporaries(execution *object.Execution) func() {
+	mark := len(execution.Temporaries)
+	return func() { execution.Temporaries = execution.Temporaries[:mark] }
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -141,6 +239,8 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalTildePrefixOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -160,11 +260,22 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+}
+
+func evalTildePrefixOperatorExpression(right object.Object) object.Object {
+	integer, ok := right.(*object.Integer)
+	if !ok {
+		return newError("unknown operator: ~%s", right.Type())
+	}
+	return &object.Integer{Value: ^integer.Value}
 }
 
 func evalInfixExpression(
@@ -173,12 +284,15 @@ func evalInfixExpression(
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		// at least one of them is a float, so the integer is converted to float
+		return evalFloatInfixExpression(operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -187,6 +301,23 @@ func evalInfixExpression(
 	}
 }
 
+// evalLogicalExpression evaluates `&&` and `||`. The right side is only evaluated
+// if the left one doesn't determine the result, which is always a boolean.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isInterrupted(left) {
+		return left
+	}
+	if isTruthy(left) == (node.Operator == "||") {
+		return nativeBoolToBooleanObject(isTruthy(left))
+	}
+	right := Eval(node.Right, env)
+	if isInterrupted(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 func evalIntegerInfixExpression(operator string,
 	left, right object.Object,
 ) object.Object {
@@ -200,11 +331,39 @@ func evalIntegerInfixExpression(operator string,
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		return powInteger(leftVal, rightVal)
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		if operator == "<<" {
+			return &object.Integer{Value: leftVal << uint64(rightVal)}
+		}
+		return &object.Integer{Value: leftVal >> uint64(rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -215,21 +374,95 @@ func evalIntegerInfixExpression(operator string,
 	}
 }
 
-func evalStringInfixExpression(operator string,
+func evalFloatInfixExpression(operator string,
 	left, right object.Object,
 ) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
 	}
+}
+
+// evalStringInfixExpression concatenates strings with `+` and compares them
+// lexicographically, byte by byte
+func evalStringInfixExpression(operator string,
+	left, right object.Object,
+) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
 
+// powInteger returns base ** exp. A negative exponent gives a float, as in
+// `2 ** -1 == 0.5`.
+func powInteger(base, exp int64) object.Object {
+	if exp < 0 {
+		return &object.Float{Value: math.Pow(float64(base), float64(exp))}
+	}
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return &object.Integer{Value: result}
 }
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if isInterrupted(condition) {
 		return condition
 	}
 	if isTruthy(condition) {
@@ -241,13 +474,150 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+// compoundOperators maps the compound assignments to the operator they apply
+var compoundOperators = map[string]string{
+	"+=": "+",
+	"-=": "-",
+	"*=": "*",
+	"/=": "/",
+}
+
+// evalAssignStatement updates the variable where it was declared, or the element
+// of the array or hash. Like let, it doesn't produce any value.
+func evalAssignStatement(node *ast.AssignStatement, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		current, ok := env.Get(target.Value)
+		if !ok {
+			return newError("assignment to undeclared identifier: %s", target.Value)
+		}
+		val := evalAssignedValue(node, current, env)
+		if isInterrupted(val) {
+			return val
+		}
+		env.Assign(target.Value, val)
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isInterrupted(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isInterrupted(index) {
+			return index
+		}
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+		val := evalAssignedValue(node, current, env)
+		if isInterrupted(val) {
+			return val
+		}
+		if err := object.SetIndex(left, index, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evalAssignedValue evaluates the value of the assignment, applying the operator
+// to the current value of the target in the compound assignments like `x += 1`
+func evalAssignedValue(node *ast.AssignStatement, current object.Object, env *object.Environment) object.Object {
+	val := Eval(node.Value, env)
+	if isInterrupted(val) {
+		return val
+	}
+	if operator, ok := compoundOperators[node.Operator]; ok {
+		return allocate(evalInfixExpression(operator, current, val), env.Execution())
+	}
+	return val
+}
+
+func evalWhileExpression(we *ast.WhileExpression, env *object.Environment) object.Object {
+	for {
+		condition := Eval(we.Condition, env)
+		if isInterrupted(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+		result := Eval(we.Body, env)
+		if result == BREAK {
+			return NULL
+		}
+		if isReturnOrError(result) {
+			return result
+		}
+	}
+}
+
+// evalForExpression binds the variable to each element of the iterable in the
+// current environment, so it's still visible after the loop, like a let
+func evalForExpression(fe *ast.ForExpression, env *object.Environment) object.Object {
+	iterable := Eval(fe.Iterable, env)
+	if isInterrupted(iterable) {
+		return iterable
+	}
+	iterator, ok := object.NewIterator(iterable)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+	for {
+		element, ok := iterator.Next()
+		if !ok {
+			return NULL
+		}
+		env.Set(fe.Variable.Value, element)
+		result := Eval(fe.Body, env)
+		if result == BREAK {
+			return NULL
+		}
+		if isReturnOrError(result) {
+			return result
+		}
+	}
+}
+
+// evalTryExpression runs the catch block if the try block fails, and then the
+// finally block, whose result is discarded unless it leaves the try expression
+// itself with a return, an error, break or continue. The errors of the limits
+// of the execution skip both blocks.
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Block, env)
+	if err, ok := result.(*object.Error); ok && err.Kind == object.LimitError {
+		return err
+	}
+	if err, ok := result.(*object.Error); ok && te.Catch != nil {
+		env.Set(te.CatchVariable.Value, err.Value())
+		result = Eval(te.Catch, env)
+	}
+	if te.Finally != nil {
+		finally := Eval(te.Finally, env)
+		if isReturnOrError(finally) || finally == BREAK || finally == CONTINUE {
+			return finally
+		}
+	}
+	return result
+}
+
+// isReturnOrError reports whether the result of a loop body must stop the loop
+// and be propagated
+func isReturnOrError(obj object.Object) bool {
+	return obj != nil &&
+		(obj.Type() == object.RETURN_VALUE_OBJ || obj.Type() == object.ERROR_OBJ)
+}
+
 func evalIdentifier(
 	node *ast.Identifier, env *object.Environment,
 ) object.Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := env.Builtins().Lookup(node.Value); ok {
 		return builtin
 	}
 	return newError("identifier not found: " + node.Value)
@@ -259,7 +629,7 @@ func evalExpressions(
 	var result []object.Object
 	for _, e := range exps {
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isInterrupted(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -301,25 +671,105 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 	return pair.Value
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// Call calls fn, a function or a builtin, with the given arguments. Go hosts use
+// it to call back the functions of a script, which run in the execution of env.
+func Call(fn object.Object, args []object.Object, env *object.Environment) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("internal error: %v", r)
+		}
+	}()
+	return applyFunction(fn, args, env.Execution(), token.Position{})
+}
+
+// applyFunction calls the function with the given arguments. If the call fails,
+// it's added to the stack trace of the error.
+func applyFunction(
+	fn object.Object, args []object.Object, execution *object.Execution, pos token.Position,
+) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
+		numDefaults := 0
+		for _, def := range fn.Defaults {
+			if def != nil {
+				numDefaults++
+			}
+		}
+		if err := object.CheckArguments(len(fn.Parameters), numDefaults, fn.Rest != nil, len(args)); err != nil {
+			return err
+		}
+		execution.Depth++
+		defer func() { execution.Depth-- }()
+		if err := execution.CheckDepth(execution.Depth); err != nil {
+			return err
+		}
+		if execution.Depth > MaxCallDepth {
+			return newError("stack overflow")
+		}
+		extendedEnv, err := extendFunctionEnv(fn, args, execution)
+		if err != nil {
+			return addStackFrame(err, fn, pos)
+		}
+		execution.Frames = append(execution.Frames, extendedEnv)
+		defer func() { execution.Frames = execution.Frames[:len(execution.Frames)-1] }()
 		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		return addStackFrame(unwrapReturnValue(evaluated), fn, pos)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		result := fn.Fn(execution, args...)
+		if result == nil {
+			return NULL
+		}
+		if fn.Allocates {
+			return allocate(result, execution)
+		}
+		return result
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
-func extendFunctionEnv(fn *object.Function, args []object.Object,
-) *object.Environment {
+
+// extendFunctionEnv binds the arguments to the parameters of the function. The
+// default values of the missing ones are evaluated in the new environment, in
+// order, so they can refer to the previous parameters.
+func extendFunctionEnv(fn *object.Function, args []object.Object, execution *object.Execution,
+) (*object.Environment, object.Object) {
 	env := object.NewEnclosedEnvironment(fn.Env)
+	env.SetExecution(execution)
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if paramIdx < len(args) {
+			env.Set(param.Value, args[paramIdx])
+		} else {
+			env.Set(param.Value, NULL)
+		}
 	}
-	return env
+	for paramIdx := len(args); paramIdx < len(fn.Parameters); paramIdx++ {
+		value := Eval(fn.Defaults[paramIdx], env)
+		if isError(value) {
+			return nil, value
+		}
+		env.Set(fn.Parameters[paramIdx].Value, value)
+	}
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		array := allocate(&object.Array{Elements: rest}, execution)
+		if isError(array) {
+			return nil, array
+		}
+		env.Set(fn.Rest.Value, array)
+	}
+	return env, nil
+}
+
+// addStackFrame adds the call to the function to the stack trace of the result,
+// if it's an error
+func addStackFrame(result object.Object, fn *object.Function, pos token.Position) object.Object {
+	if err, ok := result.(*object.Error); ok {
+		err.Stack = append(err.Stack, object.StackFrame{Function: fn.Name, Pos: pos})
+	}
+	return result
 }
 
 func evalHashLiteral(
@@ -328,7 +778,7 @@ func evalHashLiteral(
 	pairs := make(map[object.HashKey]object.HashPair)
 	for keyNode, valueNode := range node.Pairs {
 		key := Eval(keyNode, env)
-		if isError(key) {
+		if isInterrupted(key) {
 			return key
 		}
 		hashKey, ok := key.(object.Hashable)
@@ -336,7 +786,7 @@ func evalHashLiteral(
 			return newError("unusable as hash key: %s", key.Type())
 		}
 		value := Eval(valueNode, env)
-		if isError(value) {
+		if isInterrupted(value) {
 			return value
 		}
 		hashed := hashKey.HashKey()
@@ -345,6 +795,15 @@ func evalHashLiteral(
 	return &object.Hash{Pairs: pairs}
 }
 
+// allocate counts the bytes of a new string, array or hash towards the limit
+// of the execution
+func allocate(obj object.Object, execution *object.Execution) object.Object {
+	if err := execution.Allocate(obj); err != nil {
+		return err
+	}
+	return obj
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -369,6 +828,34 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// isNumber returns true for integers and floats
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat returns the value of an integer or float object as a float64
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
+// isInterrupted reports whether the value of a sub-expression stops the
+// evaluation of the expression that contains it: an error, or a return, break
+// or continue of a block inside it, like in `[x, if (x > 1) { break }]`
+func isInterrupted(obj object.Object) bool {
+	if obj != nil {
+		rt := obj.Type()
+		return rt == object.ERROR_OBJ || rt == object.RETURN_VALUE_OBJ || rt == object.LOOP_CONTROL_OBJ
+	}
+	return false
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
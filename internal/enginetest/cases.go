@@ -0,0 +1,580 @@
+package enginetest
+
+import "github.com/juandspy/monkey-lang/object"
+
+var tables = []struct {
+	name  string
+	cases []Case
+}{
+	{"IntegerArithmetic", integerArithmetic},
+	{"FloatArithmetic", floatArithmetic},
+	{"BooleanExpressions", booleanExpressions},
+	{"LogicalOperators", logicalOperators},
+	{"Conditionals", conditionals},
+	{"ReturnStatements", returnStatements},
+	{"ErrorHandling", errorHandling},
+	{"ErrorPositions", errorPositions},
+	{"StackTrace", stackTrace},
+	{"TryCatch", tryCatch},
+	{"Loops", loops},
+	{"Assignments", assignments},
+	{"LetStatements", letStatements},
+	{"Functions", functions},
+	{"FunctionArguments", functionArguments},
+	{"Closures", closures},
+	{"RecursiveFunctions", recursiveFunctions},
+	{"RecursionStackOverflow", recursionStackOverflow},
+	{"Strings", strings},
+	{"BuiltinFunctions", builtinFunctions},
+	{"ArrayLiterals", arrayLiterals},
+	{"HashLiterals", hashLiterals},
+	{"IndexExpressions", indexExpressions},
+}
+
+var null = &object.Null{}
+
+var integerArithmetic = []Case{
+	{"5", 5},
+	{"10", 10},
+	{"-5", -5},
+	{"-10", -10},
+	{"5 + 5 + 5 + 5 - 10", 10},
+	{"2 * 2 * 2 * 2 * 2", 32},
+	{"-50 + 100 + -50", 0},
+	{"5 * 2 + 10", 20},
+	{"5 + 2 * 10", 25},
+	{"20 + 2 * -10", 0},
+	{"50 / 2 * 2 + 10", 60},
+	{"2 * (5 + 10)", 30},
+	{"3 * 3 * 3 + 10", 37},
+	{"3 * (3 * 3) + 10", 37},
+	{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	{"7 % 3", 1},
+	{"-7 % 3", -1},
+	{"2 ** 10", 1024},
+	{"2 ** 3 ** 2", 512},
+	{"-2 ** 2", -4},
+	{"3 * 2 ** 2", 12},
+	{"6 & 3", 2},
+	{"6 | 3", 7},
+	{"6 ^ 3", 5},
+	{"~5", -6},
+	{"1 << 4", 16},
+	{"-16 >> 2", -4},
+	{"1 + 2 << 1", 6},
+	{"1 | 6 & 3 ^ 8", 11},
+}
+
+var floatArithmetic = []Case{
+	{"1.5", 1.5},
+	{"-1.5", -1.5},
+	{"1.5 + 1.5", 3.0},
+	{"1 + .5", 1.5},
+	{"10 / 4.0", 2.5},
+	{"2 * 1e-3", 0.002},
+	{"(1 + 2 + 3) / 3.0", 2.0},
+	{"float(7) / 2", 3.5},
+	{`float("2.5")`, 2.5},
+	{"round(2.567, 2)", 2.57},
+	{"7.5 % 2", 1.5},
+	{"4 ** 0.5", 2.0},
+	{"2 ** -1", 0.5},
+	{"1.5 < 2", true},
+	{"2 > 1.5", true},
+	{"1 == 1.0", true},
+	{"0.1 + 0.2 != 0.3", true},
+	{"int(2.9)", 2},
+	{"int(-2.9)", -2},
+	{`int("42")`, 42},
+	{`int("foo")`, Error(`could not convert "foo" to INTEGER`)},
+	{"round(2.5)", 3},
+	{"round(3, 2)", 3},
+	{"floor(2.9)", 2},
+	{"ceil(2.1)", 3},
+	{"floor(-2.1)", -3},
+	{"ceil(4)", 4},
+	{`floor("a")`, Error("argument to `floor` must be INTEGER or FLOAT, got STRING")},
+	{"-true + 1.5", Error("unknown operator: -BOOLEAN")},
+	{"1.5 + true", Error("type mismatch: FLOAT + BOOLEAN")},
+}
+
+var booleanExpressions = []Case{
+	{"true", true},
+	{"false", false},
+	{"1 < 2", true},
+	{"1 > 2", false},
+	{"1 < 1", false},
+	{"1 > 1", false},
+	{"1 == 1", true},
+	{"1 != 1", false},
+	{"1 == 2", false},
+	{"1 != 2", true},
+	{"true == true", true},
+	{"false == false", true},
+	{"true == false", false},
+	{"true != false", true},
+	{"false != true", true},
+	{"(1 < 2) == true", true},
+	{"(1 < 2) == false", false},
+	{"(1 > 2) == true", false},
+	{"(1 > 2) == false", true},
+	{"1 <= 2", true},
+	{"2 <= 2", true},
+	{"3 <= 2", false},
+	{"1 >= 2", false},
+	{"2 >= 2", true},
+	{"2.5 >= 2", true},
+	{"1 | 2 == 3", true},
+	{`"abc" < "abd"`, true},
+	{`"b" > "abc"`, true},
+	{`"a" <= "a"`, true},
+	{`"a" >= "b"`, false},
+	{`"monkey" == "mon" + "key"`, true},
+	{`"monkey" != "monkey"`, false},
+	{"!true", false},
+	{"!false", true},
+	{"!5", false},
+	{"!!true", true},
+	{"!!false", false},
+	{"!!5", true},
+	{"!(if (false) { 5; })", true},
+}
+
+var logicalOperators = []Case{
+	{"true && true", true},
+	{"true && false", false},
+	{"false && true", false},
+	{"true || false", true},
+	{"false || true", true},
+	{"false || false", false},
+	{"1 && 2", true},
+	{"0 || false", true},
+	{"(if (false) { 1 }) || false", false},
+	{"1 < 2 && 2 < 3", true},
+	{"1 > 2 || 2 > 3", false},
+	{"false && (1 + true)", false},
+	{"true || (1 + true)", true},
+	{"true && (1 + true)", Error("type mismatch: INTEGER + BOOLEAN")},
+	{"let x = 1; let f = fn() { x = 2; true }; false && f(); x", 1},
+	{"let x = 1; let f = fn() { x = 2; true }; true && f(); x", 2},
+}
+
+var conditionals = []Case{
+	{"if (true) { 10 }", 10},
+	{"if (false) { 10 }", null},
+	{"if (1) { 10 }", 10},
+	{"if (1 < 2) { 10 }", 10},
+	{"if (1 > 2) { 10 }", null},
+	{"if (1 > 2) { 10 } else { 20 }", 20},
+	{"if (1 < 2) { 10 } else { 20 }", 10},
+	{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	{"if (true) { let a = 1; }", null},
+	{"if (true) { }", null},
+	{"let x = if (true) { }; x", null},
+	{"let x = if (true) { let a = 1; }; puts(x); x", null},
+}
+
+var returnStatements = []Case{
+	{"return 10;", 10},
+	{"return 10; 9;", 10},
+	{"return 2 * 5; 9;", 10},
+	{"9; return 2 * 5; 9;", 10},
+	{"if (10 > 1) { return 10; }", 10},
+	{`
+	if (10 > 1) {
+	  if (10 > 1) {
+		return 10;
+	  }
+
+	  return 1;
+	}
+	`, 10},
+	{`
+	let f = fn(x) {
+	  return x;
+	  x + 10;
+	};
+	f(10);`, 10},
+	{`
+	let f = fn(x) {
+	   let result = x + 10;
+	   return result;
+	   return 10;
+	};
+	f(10);`, 20},
+}
+
+var errorHandling = []Case{
+	{"5 + true;", Error("type mismatch: INTEGER + BOOLEAN")},
+	{"5 + true; 5;", Error("type mismatch: INTEGER + BOOLEAN")},
+	{"1 << -1", Error("negative shift count: -1")},
+	{"~1.5", Error("unknown operator: ~FLOAT")},
+	{"1.5 & 1", Error("unknown operator: FLOAT & INTEGER")},
+	{"-true", Error("unknown operator: -BOOLEAN")},
+	{"true + false;", Error("unknown operator: BOOLEAN + BOOLEAN")},
+	{"5; true + false; 5", Error("unknown operator: BOOLEAN + BOOLEAN")},
+	{"if (10 > 1) { true + false; }", Error("unknown operator: BOOLEAN + BOOLEAN")},
+	{`
+	if (10 > 1) {
+		if (10 > 1) {
+		  return true + false;
+		}
+	  return 1; }
+	  `, Error("unknown operator: BOOLEAN + BOOLEAN")},
+	{"foobar", Error("identifier not found: foobar")},
+	{`"Hello" - "World"`, Error("unknown operator: STRING - STRING")},
+	{`{"name": "Monkey"}[fn(x) { x }];`, Error("unusable as hash key: FUNCTION")},
+	{`fn(a, b) { a + b }(1)`, Error("wrong number of arguments: want=2, got=1")},
+	{`5(1)`, Error("not a function: INTEGER")},
+	{"10 / 0", Error("division by zero")},
+	{"10 % (1 - 1)", Error("division by zero")},
+	{"1.5 / 0", Error("division by zero")},
+	{"let x = 1; x /= 0;", Error("division by zero")},
+	{"let a = [1]; a[0] /= 0;", Error("division by zero")},
+}
+
+var errorPositions = []Case{
+	{"5 + true;", Located("ERROR: script.mk:1:3: type mismatch: INTEGER + BOOLEAN")},
+	{"let x = 1;\nlet y = x + foobar;", Located("ERROR: script.mk:2:13: identifier not found: foobar")},
+	{"let f = fn() {\n  -true\n};\nf()", Located("ERROR: script.mk:2:3: unknown operator: -BOOLEAN")},
+	{"let x = 1;\nlen(x)", Located("ERROR: script.mk:2:4: argument to `len` not supported, got INTEGER")},
+}
+
+var stackTrace = []Case{
+	{
+		"let inner = fn(x) {\n  x + true\n};\nlet outer = fn(x) { inner(x) };\nlet apply = fn(f, x) { f(x) };\napply(outer, 1);",
+		Traceback("Traceback (most recent call last):\n" +
+			"  script.mk:6:6 in <main>\n" +
+			"  script.mk:5:25 in apply\n" +
+			"  script.mk:4:26 in outer\n" +
+			"  script.mk:2:5 in inner\n"),
+	},
+	{
+		"let apply = fn(f) { f() };\napply(fn() { -true })",
+		Traceback("Traceback (most recent call last):\n" +
+			"  script.mk:2:6 in <main>\n" +
+			"  script.mk:1:22 in apply\n" +
+			"  script.mk:2:14 in <anonymous>\n"),
+	},
+	{
+		"let f = fn(a = -true) { a };\nf()",
+		Traceback("Traceback (most recent call last):\n" +
+			"  script.mk:2:2 in <main>\n" +
+			"  script.mk:1:16 in f\n"),
+	},
+	{"let f = fn(a) { a };\nf()", Traceback("")},
+	{"-true", Traceback("")},
+}
+
+var tryCatch = []Case{
+	{`try { 1 } catch (e) { 2 }`, 1},
+	{`try { 1 + true } catch (e) { 2 }`, 2},
+	{`try { 1 + true } catch (e) { e["message"] == "type mismatch: INTEGER + BOOLEAN" }`, true},
+	{`try { throw "bad" } catch (e) { e["message"] == "bad" && e["type"] == "Error" }`, true},
+	{`try { throw {"message": "bad", "type": "ValueError"} } catch (e) { e["type"] == "ValueError" }`, true},
+	{`try { throw {"message": "bad", "type": "LimitError"} } catch (e) { e["type"] == "Error" }`, true},
+	{`try { throw "bad" } catch (e) { e["position"] == "script.mk:1:7" }`, true},
+	{`let f = fn() { {}["missing"] + 1 }; try { f() } catch (e) { len(e["stack"]) }`, 1},
+	{`let f = fn() { try { throw "a" } catch (e) { e } }; f()["message"] == "a"`, true},
+	{`try { try { throw "a" } catch (e) { throw e } } catch (e) { e["message"] == "a" }`, true},
+	{`try { 1 } catch (e) { 2 } finally { 3 }`, 1},
+	{`let x = 0; try { x = 1 } finally { x = x + 10 }; x`, 11},
+	{`let x = 0; try { try { throw "a" } finally { x = 1 } } catch (e) { x + 1 }`, 2},
+	{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+	{`let x = 0; let f = fn() { try { return 1 } finally { x = 5 } }; f() + x`, 6},
+	{`let s = 0; for (i in range(5)) { try { if (i == 3) { break } s += i } finally { s += 10 } }; s`, 43},
+	{`let s = 0; for (i in range(3)) { try { if (i == 1) { continue } s += i } finally { s += 10 } }; s`, 32},
+	{`try { throw "a" } catch (e) { throw "b" }`, Error("b")},
+	{`try { throw "a" } finally { 1 }`, Error("a")},
+	{`try { throw "a" } catch (e) { 1 } finally { 1 + true }`, Error("type mismatch: INTEGER + BOOLEAN")},
+	{`throw 1`, Error("cannot throw INTEGER, want STRING or HASH")},
+	// rethrowing a caught error keeps its message
+	{`try { throw "a" } catch (e) { throw e }`, Located("ERROR: script.mk:1:31: a")},
+	{`try { 1 / 0 } catch (e) { throw e }`, Located("ERROR: script.mk:1:27: division by zero")},
+	{`try { throw {"message": "a", "type": "ValueError"} } catch (e) { throw e }`, Located("ERROR: script.mk:1:66: ValueError: a")},
+	{`try { throw "a" } catch (e) { e["type"] }`, "Error"},
+	// the handlers must not leave anything in the stack
+	{`let s = 0; for (i in range(5000)) { try { s += 1; if (true) { continue } } finally { 1 } }; s`, 5000},
+	{`let f = fn(x) { [x, x + true] }; let s = 0; for (i in range(5000)) { s += try { f(i) } catch (e) { 1 } }; s`, 5000},
+	{`let f = fn() { try { return 1 } catch (e) { 2 } }; let s = 0; for (i in range(5000)) { s += f() }; s`, 5000},
+}
+
+var loops = []Case{
+	{"let i = 0; while (i < 10) { let i = i + 1; }; i", 10},
+	{"while (false) { 1 }", null},
+	{"let i = 0; while (true) { let i = i + 1; if (i == 5) { break; } }; i", 5},
+	{"let s = 0; let i = 0; while (i < 5) { let i = i + 1; if (i == 2) { continue; } let s = s + i; }; s", 13},
+	{"let s = 0; for (x in [1, 2, 3]) { let s = s + x; }; s", 6},
+	{"let s = 0; for (x in range(5)) { let s = s + x; }; s", 10},
+	{"let s = 0; for (x in range(2, 10, 3)) { let s = s + x; }; s", 15},
+	{"let s = 0; for (x in range(10, 0, -1)) { let s = s + x; }; s", 55},
+	{"let s = 0; for (x in range(0)) { let s = s + 1; }; s", 0},
+	{`let s = ""; for (c in "héllo") { let s = c + s; }; len(s)`, 6},
+	{`let s = ""; for (k in {"b": 1, "a": 2, "c": 3}) { let s = s + k; }; len(s)`, 3},
+	{"let s = 0; for (k in {3: 0, 1: 0, 2: 0}) { let s = s * 10 + k; }; s", 123},
+	{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } let s = s + x; }; s", 3},
+	{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { continue; } let s = s + x; }; s", 7},
+	{"for (x in [1, 2]) { x }", null},
+	{"for (x in [1, 2]) { }; x", 2},
+	{"let f = fn(arr) { for (x in arr) { if (x > 1) { return x; } }; -1 }; f([1, 5, 2])", 5},
+	{"let f = fn(arr) { for (x in arr) { if (x > 9) { return x; } }; -1 }; f([1, 5, 2])", -1},
+	{"let s = 0; for (x in range(3)) { for (y in range(3)) { if (y > x) { break; } let s = s + 1; } }; s", 6},
+	{"for (x in 5) { x }", Error("cannot iterate over INTEGER")},
+	{"for (x in [1]) { x + true }", Error("type mismatch: INTEGER + BOOLEAN")},
+	{"while (1 + true) { 1 }", Error("type mismatch: INTEGER + BOOLEAN")},
+	{"range(1, 2, 0)", Error("range step cannot be zero")},
+	{"len(range(1, 10, 2))", 5},
+	// a break, continue or return inside a sub-expression ends the
+	// expression, like a statement does
+	{"let n = 0; for (x in [1, 2]) { let a = [x, if (x == 2) { break }]; n = n + len(a) }; n", 2},
+	{"let n = 0; for (x in [1, 2, 3]) { let a = [x, if (x == 2) { continue }]; n = n + a[0] }; n", 4},
+	{"let n = 0; for (x in [1, 2]) { n = n + x; puts(if (x == 1) { break }) }; n", 1},
+	{"let n = 0; for (x in [1, 2]) { let h = {x: if (true) { break }}; n = 1 }; n", 0},
+	{"let n = 0; for (x in [1, 2]) { let y = 1 + if (true) { continue }; n = 1 }; n", 0},
+	{"let n = 0; for (x in [1, 2]) { let y = [1][if (true) { break }]; n = 1 }; n", 0},
+	{"let f = fn() { let a = [1, if (true) { return 5 }]; 0 }; f()", 5},
+	{"let n = 0; [7, while (n < 3) { n = n + 1; [n, if (true) { continue }] }][0]", 7},
+	// the loops must not leave anything in the stack
+	{"let s = 0; for (x in range(5000)) { if (true) { let s = s + 1; continue; } }; s", 5000},
+	{"let i = 0; while (i < 5000) { let i = i + 1; i; if (true) { i } }; i", 5000},
+	{"for (x in range(5000)) { for (y in [1]) { break; } }; 1", 1},
+}
+
+var assignments = []Case{
+	{"let x = 1; x = 2; x", 2},
+	{"let x = 1; x += 2; x", 3},
+	{"let x = 10; x -= 2; x", 8},
+	{"let x = 3; x *= 4; x", 12},
+	{"let x = 12; x /= 4; x", 3},
+	{"let x = 1.5; x += 1; x", 2.5},
+	{`let s = "a"; s += "b"; s`, "ab"},
+	{"let x = 1; x = 2;", nil},
+	{"let x = 1; let f = fn() { x = 5; }; f(); x", 5},
+	{"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c(); c()", 3},
+	{"let counter = fn(n) { fn() { n += 1; n } }; let c = counter(10); c(); c()", 12},
+	{"let ca = fn() { let n = 0; fn() { n += 1; n } }(); let cb = fn() { let n = 0; fn() { n += 1; n } }(); ca(); ca(); cb()", 1},
+	{"let f = fn() { let x = 1; let g = fn() { x }; x = 2; g() }; f()", 2},
+	{"let f = fn() { let x = 1; let g = fn() { fn() { x = 3 } }; g()(); x }; f()", 3},
+	{"let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()", 2},
+	{"let f = fn() { let fns = []; for (i in range(3)) { fns = push(fns, fn() { i }) }; fns[0]() }; f()", 2},
+	// a function assigning its own name changes the binding of the let
+	{"let f = fn() { f = 1 }; f(); f", 1},
+	{"let f = fn(n) { if (n > 0) { f(n - 1) } else { f = 5 } }; f(3); f", 5},
+	{"let f = fn() { let h = fn() { f = 3 }; h() }; f(); f", 3},
+	{"let g = fn() { let f = fn() { f = 2 }; f(); f }; g()", 2},
+	{"let a = [1, 2, 3]; a[0] = 10; a", []int{10, 2, 3}},
+	{"let a = [1, 2, 3]; a[1] *= 5; a[1]", 10},
+	{"let a = [1, 2, 3]; let b = a; b[2] = 0; a[2]", 0},
+	{"let f = fn() { let a = [0]; let g = fn() { a[0] += 1 }; g(); g(); a[0] }; f()", 2},
+	{`let h = {"a": 1}; h["b"] = 2; h["a"] += 10; h["a"] + h["b"]`, 13},
+	{`let h = {}; h[1] = "one"; h[true] = "yes"; h[1] + h[true]`, "oneyes"},
+	{"let h = {}; h[2] = 1; h[2] += 1; h[2]", 2},
+	{"x = 1", Error("assignment to undeclared identifier: x")},
+	{"len = 1", Error("assignment to undeclared identifier: len")},
+	{"let x = 1; x += true", Error("type mismatch: INTEGER + BOOLEAN")},
+	{"let a = [1]; a[1] = 2", Error("index out of range: 1")},
+	{"let a = [1]; a[-1] = 2", Error("index out of range: -1")},
+	{`let a = [1]; a["0"] = 2`, Error("array index must be INTEGER, got STRING")},
+	{"let a = [1]; a[5] += 2", Error("type mismatch: NULL + INTEGER")},
+	{"let h = {}; h[fn() {}] = 1", Error("unusable as hash key: FUNCTION")},
+	{`let s = "abc"; s[0] = "x"`, Error("index assignment not supported: STRING")},
+}
+
+var letStatements = []Case{
+	{"let a = 5; a;", 5},
+	{"let a = 5 * 5; a;", 25},
+	{"let a = 5; let b = a; b;", 5},
+	{"let a = 5; let b = a; let c = a + b + 5; c;", 15},
+	{"let a = 5; let a = a + 1; a;", 6},
+	{"let a = 5;", nil},
+}
+
+var functions = []Case{
+	{"let identity = fn(x) { x; }; identity(5);", 5},
+	{"let identity = fn(x) { return x; }; identity(5);", 5},
+	{"let double = fn(x) { x * 2; }; double(5);", 10},
+	{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
+	{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+	{"fn(x) { x; }(5)", 5},
+	{"let noReturn = fn() { }; noReturn();", null},
+	{"let globalSeed = 50; let minusOne = fn() { let num = 1; globalSeed - num; }; minusOne();", 49},
+}
+
+var functionArguments = []Case{
+	{"fn(a, b) { a + b }(1)", Error("wrong number of arguments: want=2, got=1")},
+	{"fn(a) { a }(1, 2)", Error("wrong number of arguments: want=1, got=2")},
+	{"fn() { 1 }(1)", Error("wrong number of arguments: want=0, got=1")},
+	{"let f = fn(a, b = 10) { a + b }; f(1)", 11},
+	{"let f = fn(a, b = 10) { a + b }; f(1, 2)", 3},
+	{"let f = fn(a, b = 10) { a + b }; f()", Error("wrong number of arguments: want=1 to 2, got=0")},
+	{"let f = fn(a, b = 10) { a + b }; f(1, 2, 3)", Error("wrong number of arguments: want=1 to 2, got=3")},
+	{"let f = fn(a, b = a * 2, c = a + b) { [a, b, c] }; f(1)", []int{1, 2, 3}},
+	{"let f = fn(a, b = a * 2, c = a + b) { [a, b, c] }; f(1, 5)", []int{1, 5, 6}},
+	{"let x = 5; let f = fn(a = x) { a }; let x = 6; f()", 6},
+	{"let f = fn(a = 1 + true) { a }; f(2)", 2},
+	{"let f = fn(a = 1 + true) { a }; f()", Error("type mismatch: INTEGER + BOOLEAN")},
+	{"let f = fn(a, ...rest) { rest }; f(1)", []int{}},
+	{"let f = fn(a, ...rest) { rest }; f(1, 2, 3)", []int{2, 3}},
+	{"let f = fn(a, ...rest) { rest }; f()", Error("wrong number of arguments: want=1 or more, got=0")},
+	{"let f = fn(a = 1, ...rest) { push(rest, a) }; f()", []int{1}},
+	{"let f = fn(a = 1, ...rest) { push(rest, a) }; f(5, 6)", []int{6, 5}},
+	{"let sum = fn(...xs) { let s = 0; for (x in xs) { s += x; }; s }; sum(1, 2, 3)", 6},
+	{"let f = fn(...xs) { let g = fn() { xs }; xs = [1]; g() }; f(2, 3)", []int{1}},
+}
+
+var closures = []Case{
+	{`
+	let newAdder = fn(x) {
+		fn(y) { x + y };
+	};
+	let addTwo = newAdder(2);
+	addTwo(2);`, 4},
+	{`
+	let newAdderOuter = fn(a, b) {
+		let c = a + b;
+		fn(d) {
+			let e = d + c;
+			fn(f) { e + f; };
+		};
+	};
+	let newAdderInner = newAdderOuter(1, 2)
+	let adder = newAdderInner(3);
+	adder(8);`, 14},
+}
+
+var recursiveFunctions = []Case{
+	// the functions refer to the globals defined after them
+	{"let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } }; let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } }; isEven(10)", true},
+	{"let f = fn() { g() }; let g = fn() { 1 }; f()", 1},
+	{"let f = fn() { g() }; f(); let g = fn() { 1 };", Error("identifier not found: g")},
+	{`
+	let countDown = fn(x) {
+		if (x == 0) {
+			return 0;
+		} else {
+			countDown(x - 1);
+		}
+	};
+	countDown(1);`, 0},
+	{`
+	let wrapper = fn() {
+		let countDown = fn(x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				countDown(x - 1);
+			}
+		};
+		countDown(1);
+	};
+	wrapper();`, 0},
+	{`
+	let fibonacci = fn(x) {
+		if (x == 0) {
+			return 0;
+		} else {
+			if (x == 1) {
+				return 1;
+			} else {
+				fibonacci(x - 1) + fibonacci(x - 2);
+			}
+		}
+	};
+	fibonacci(15);`, 610},
+}
+
+var recursionStackOverflow = []Case{
+	{"let f = fn(x) { f(x + 1) }; f(0)", Error("stack overflow")},
+	{`let f = fn(x) { f(x + 1) }; try { f(0) } catch (e) { e["message"] == "stack overflow" }`, true},
+	// both engines overflow at the same depth
+	{`let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(9999)`, 9999},
+	{`let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; try { f(10000) } catch (e) { e["message"] == "stack overflow" }`, true},
+}
+
+var strings = []Case{
+	{`"Hello World!"`, "Hello World!"},
+	{`"Hello" + " " + "World!"`, "Hello World!"},
+}
+
+var builtinFunctions = []Case{
+	{`len("")`, 0},
+	{`len("four")`, 4},
+	{`len("hello world")`, 11},
+	{`len(1)`, Error("argument to `len` not supported, got INTEGER")},
+	{`len("one", "two")`, Error("wrong number of arguments. got=2, want=1")},
+	{`len([1, 2, 3])`, 3},
+	{`len([])`, 0},
+	{`first([1, 2, 3])`, 1},
+	{`first([])`, null},
+	{`first(1)`, Error("argument to `first` must be ARRAY, got INTEGER")},
+	{`last([1, 2, 3])`, 3},
+	{`last([])`, null},
+	{`last(1)`, Error("argument to `last` must be ARRAY, got INTEGER")},
+	{`rest([1, 2, 3])`, []int{2, 3}},
+	{`rest([])`, null},
+	{`push([], 1)`, []int{1}},
+	{`push(1, 1)`, Error("argument to `push` must be ARRAY, got INTEGER")},
+	{`len("años")`, 5},
+	{`len(chars("años"))`, 4},
+	{`len(chars("🐵🙈"))`, 2},
+	{`chars("🐵ñ")`, []string{"🐵", "ñ"}},
+	{`chars(1)`, Error("argument to `chars` must be STRING, got INTEGER")},
+	{`puts("hello")`, null},
+	{`readline(1)`, Error("wrong number of arguments. got=1, want=0")},
+	{`input("a", "b")`, Error("wrong number of arguments. got=2, want=0 or 1")},
+	{`math.sqrt(16)`, 4.0},
+	{`math.abs(-3)`, 3},
+	{`round(math.sqrt(16))`, 4},
+	{`math.sqrt("a")`, Error("argument to `math.sqrt` must be INTEGER or FLOAT, got STRING")},
+	{`math.cbrt(8)`, Error("not a function: NULL")},
+	{`strings.split("a,b", ",")`, []string{"a", "b"}},
+	{`len(strings.split("a,b,c", ","))`, 3},
+	{`strings.join(["a", "b"], ", ")`, "a, b"},
+	{`strings.join([1], "")`, Error("elements joined by `strings.join` must be STRING, got INTEGER")},
+}
+
+var arrayLiterals = []Case{
+	{"[]", []int{}},
+	{"[1, 2 * 2, 3 + 3]", []int{1, 4, 6}},
+}
+
+var hashLiterals = []Case{
+	{"{}", map[object.HashKey]int64{}},
+	{`let two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+	}`, map[object.HashKey]int64{
+		(&object.String{Value: "one"}).HashKey():   1,
+		(&object.String{Value: "two"}).HashKey():   2,
+		(&object.String{Value: "three"}).HashKey(): 3,
+		(&object.Integer{Value: 4}).HashKey():      4,
+		(&object.Boolean{Value: true}).HashKey():   5,
+		(&object.Boolean{Value: false}).HashKey():  6,
+	}},
+}
+
+var indexExpressions = []Case{
+	{`{"foo": 5}["foo"]`, 5},
+	{`{"foo": 5}["bar"]`, null},
+	{`let key = "foo"; {"foo": 5}[key]`, 5},
+	{`{}["foo"]`, null},
+	{`{5: 5}[5]`, 5},
+	{`{true: 5}[true]`, 5},
+	{`{false: 5}[false]`, 5},
+	{"[1, 2, 3][0]", 1},
+	{"[1, 2, 3][1]", 2},
+	{"[1, 2, 3][2]", 3},
+	{"let i = 0; [1][i];", 1},
+	{"[1, 2, 3][1 + 1];", 3},
+	{"let myArray = [1, 2, 3]; myArray[2];", 3},
+	{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+	{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+	{"[1, 2, 3][3]", null},
+	{"[1, 2, 3][-1]", null},
+	{"1[0]", Error("index operator not supported: INTEGER")},
+}
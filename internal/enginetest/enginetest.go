@@ -0,0 +1,234 @@
+// Package enginetest holds the test cases shared by the evaluator and the VM.
+// Both engines run the same cases, so that they behave the same way.
+package enginetest
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/juandspy/monkey-lang/ast"
+	"github.com/juandspy/monkey-lang/lexer"
+	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/parser"
+)
+
+// Engine runs the program within the limits. It returns the value of the last
+// expression statement, or nil if there's none, the resources it used and the
+// error that stopped it.
+type Engine func(
+	ctx context.Context, program *ast.Program, limits object.Limits,
+) (object.Object, object.Usage, error)
+
+// Case is a program and the result of running it. The expected result can be:
+//
+//	int, float64, bool, string  an object with that value
+//	[]int, []string             an array with those elements
+//	map[object.HashKey]int64    a hash with those pairs
+//	*object.Null                null
+//	nil                         no result, as when the last statement is a let
+//	Error, Located, Traceback   an error
+type Case struct {
+	Input    string
+	Expected interface{}
+}
+
+// Error is used to expect an error with the given message
+type Error string
+
+// Located is used to expect an error that prints as the given string, with its
+// position. The programs are parsed as the file script.mk.
+type Located string
+
+// Traceback is used to expect an error with the given stack trace
+type Traceback string
+
+// Filename is the name of the file the programs are parsed from
+const Filename = "script.mk"
+
+// Run runs all the cases with the engine, each table as a subtest
+func Run(t *testing.T, engine Engine) {
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			for _, tt := range table.cases {
+				program := parse(t, tt.Input)
+				result, _, err := engine(context.Background(), program, object.Limits{})
+				Check(t, tt.Input, tt.Expected, result, err)
+			}
+		})
+	}
+	t.Run("ExecutionLimits", func(t *testing.T) { testExecutionLimits(t, engine) })
+	t.Run("Usage", func(t *testing.T) { testUsage(t, engine) })
+	t.Run("PeakMemory", func(t *testing.T) { testPeakMemory(t, engine) })
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.NewFile(Filename, input))
+	program := p.ParseProgram()
+	if errors := p.Errors(); len(errors) != 0 {
+		t.Errorf("%q: parser errors: %v", input, errors)
+	}
+	return program
+}
+
+// Check checks the result of running the input against the expected one, which
+// can be any of the types listed in Case
+func Check(t *testing.T, input string, expected interface{}, actual object.Object, err error) {
+	t.Helper()
+
+	if err != nil {
+		testError(t, input, expected, err)
+		return
+	}
+
+	switch expected := expected.(type) {
+	case int:
+		testIntegerObject(t, input, int64(expected), actual)
+	case float64:
+		testFloatObject(t, input, expected, actual)
+	case bool:
+		testBooleanObject(t, input, expected, actual)
+	case string:
+		testStringObject(t, input, expected, actual)
+	case []int:
+		if array := testArrayObject(t, input, len(expected), actual); array != nil {
+			for i, el := range expected {
+				testIntegerObject(t, input, int64(el), array.Elements[i])
+			}
+		}
+	case []string:
+		if array := testArrayObject(t, input, len(expected), actual); array != nil {
+			for i, el := range expected {
+				testStringObject(t, input, el, array.Elements[i])
+			}
+		}
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok {
+			t.Errorf("%q: object is not Hash. got=%T (%+v)", input, actual, actual)
+			return
+		}
+		if len(hash.Pairs) != len(expected) {
+			t.Errorf("%q: hash has wrong number of Pairs. want=%d, got=%d",
+				input, len(expected), len(hash.Pairs))
+			return
+		}
+		for expectedKey, expectedValue := range expected {
+			pair, ok := hash.Pairs[expectedKey]
+			if !ok {
+				t.Errorf("%q: no pair for given key in Pairs", input)
+				continue
+			}
+			testIntegerObject(t, input, expectedValue, pair.Value)
+		}
+	case *object.Null:
+		if _, ok := actual.(*object.Null); !ok {
+			t.Errorf("%q: object is not Null. got=%T (%+v)", input, actual, actual)
+		}
+	case nil:
+		if actual != nil {
+			t.Errorf("%q: expected no result. got=%T (%+v)", input, actual, actual)
+		}
+	case Error, Located, Traceback:
+		t.Errorf("%q: expected an error. got=%T (%+v)", input, actual, actual)
+	default:
+		t.Fatalf("%q: unsupported expected result %T", input, expected)
+	}
+}
+
+func testError(t *testing.T, input string, expected interface{}, err error) {
+	t.Helper()
+
+	errObj, ok := err.(*object.Error)
+	if !ok {
+		t.Errorf("%q: error is not *object.Error. got=%T (%+v)", input, err, err)
+		return
+	}
+	switch expected := expected.(type) {
+	case Error:
+		if errObj.Message != string(expected) {
+			t.Errorf("%q: wrong error message. expected=%q, got=%q", input, expected, errObj.Message)
+		}
+	case Located:
+		if errObj.Inspect() != string(expected) {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", input, expected, errObj.Inspect())
+		}
+	case Traceback:
+		if errObj.StackTrace() != string(expected) {
+			t.Errorf("wrong stack trace for %q.\nexpected=%q\ngot=     %q",
+				input, expected, errObj.StackTrace())
+		}
+	default:
+		t.Errorf("%q: unexpected error: %s", input, err)
+	}
+}
+
+func testIntegerObject(t *testing.T, input string, expected int64, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.Integer)
+	if !ok {
+		t.Errorf("%q: object is not Integer. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("%q: object has wrong value. got=%d, want=%d", input, result.Value, expected)
+	}
+}
+
+func testFloatObject(t *testing.T, input string, expected float64, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.Float)
+	if !ok {
+		t.Errorf("%q: object is not Float. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if math.Abs(result.Value-expected) > 1e-9 {
+		t.Errorf("%q: object has wrong value. got=%g, want=%g", input, result.Value, expected)
+	}
+}
+
+func testBooleanObject(t *testing.T, input string, expected bool, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.Boolean)
+	if !ok {
+		t.Errorf("%q: object is not Boolean. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("%q: object has wrong value. got=%t, want=%t", input, result.Value, expected)
+	}
+}
+
+func testStringObject(t *testing.T, input string, expected string, actual object.Object) {
+	t.Helper()
+
+	result, ok := actual.(*object.String)
+	if !ok {
+		t.Errorf("%q: object is not String. got=%T (%+v)", input, actual, actual)
+		return
+	}
+	if result.Value != expected {
+		t.Errorf("%q: object has wrong value. got=%q, want=%q", input, result.Value, expected)
+	}
+}
+
+// testArrayObject returns the array, or nil if it isn't one of the given length
+func testArrayObject(t *testing.T, input string, length int, actual object.Object) *object.Array {
+	t.Helper()
+
+	array, ok := actual.(*object.Array)
+	if !ok {
+		t.Errorf("%q: object is not Array. got=%T (%+v)", input, actual, actual)
+		return nil
+	}
+	if len(array.Elements) != length {
+		t.Errorf("%q: wrong num of elements. want=%d, got=%d", input, length, len(array.Elements))
+		return nil
+	}
+	return array
+}
@@ -0,0 +1,94 @@
+package enginetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/juandspy/monkey-lang/object"
+)
+
+func testExecutionLimits(t *testing.T, engine Engine) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		limits   object.Limits
+		expected string
+	}{
+		{"while (true) { }", context.Background(), object.Limits{MaxSteps: 100}, "step limit exceeded: 100"},
+		{"let f = fn(x) { f(x + 1) }; f(0)", context.Background(), object.Limits{MaxDepth: 10}, "maximum call depth exceeded: 10"},
+		{"while (true) { }", context.Background(), object.Limits{Timeout: 10 * time.Millisecond}, "execution stopped: context deadline exceeded"},
+		{"while (true) { }", cancelled, object.Limits{}, "execution stopped: context canceled"},
+		// scripts can't escape the limits by catching the errors
+		{"while (true) { try { while (true) { } } catch (e) { } }", context.Background(), object.Limits{MaxSteps: 100}, "step limit exceeded: 100"},
+		{`let s = "ab"; while (true) { s = s + s }`, context.Background(), object.Limits{MaxMemory: 1 << 20}, "memory limit exceeded: 1048576 bytes"},
+		{`let s = "ab"; while (true) { s += s }`, context.Background(), object.Limits{MaxMemory: 1 << 20}, "memory limit exceeded: 1048576 bytes"},
+		{"let a = []; while (true) { a = push(a, a) }", context.Background(), object.Limits{MaxMemory: 1 << 16}, "memory limit exceeded: 65536 bytes"},
+		{"let f = fn(...xs) { f(xs, xs) }; f()", context.Background(), object.Limits{MaxMemory: 1024}, "memory limit exceeded: 1024 bytes"},
+		// the values of the expressions being evaluated are in use too
+		{`let big = fn() { let s = "ab"; for (i in range(9)) { s = s + s }; s }; let f = fn(n) { if (n == 0) { 0 } else { [big(), f(n - 1)][1] } }; f(100)`, context.Background(), object.Limits{MaxMemory: 1 << 16}, "memory limit exceeded: 65536 bytes"},
+	}
+	for _, tt := range tests {
+		_, _, err := engine(tt.ctx, parse(t, tt.input), tt.limits)
+		errObj, ok := err.(*object.Error)
+		if !ok {
+			t.Errorf("%q: error is not *object.Error. got=%T (%+v)", tt.input, err, err)
+			continue
+		}
+		if errObj.Message != tt.expected || errObj.Kind != object.LimitError {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, tt.expected, errObj.Error())
+		}
+	}
+
+	// the values discarded are given back, so only the ones in use count
+	discarded := []Case{
+		{`let keep = ""; for (i in range(10000)) { let s = "abcdefgh" + "abcdefgh"; keep = s }; len(keep)`, 16},
+		{`let f = fn(n) { let s = "abcdefgh" + "abcdefgh"; len(s) }; let t = 0; for (i in range(10000)) { t += f(i) }; t`, 160000},
+		{`let a = [0]; for (i in range(1000)) { a = push(rest(a), i) }; a[0]`, 999},
+	}
+	for _, tt := range discarded {
+		result, _, err := engine(context.Background(), parse(t, tt.Input), object.Limits{MaxMemory: 1024})
+		Check(t, tt.Input, tt.Expected, result, err)
+	}
+}
+
+func testUsage(t *testing.T, engine Engine) {
+	input := `let f = fn(n) { if (n == 0) { return "" }; f(n - 1) + "ab" }; let s = f(3); [s, first([s])]`
+	_, usage, err := engine(context.Background(), parse(t, input), object.Limits{})
+	if err != nil {
+		t.Fatalf("%q: unexpected error: %s", input, err)
+	}
+	// the strings "ab", "abab" and "ababab", and two arrays of 1 and 2
+	// elements. The literals aren't counted, as they're constants of the
+	// bytecode in the VM.
+	if usage.Allocated != 18+20+22+40+56 {
+		t.Errorf("wrong allocated bytes. expected=%d, got=%d", 18+20+22+40+56, usage.Allocated)
+	}
+	if usage.Memory != usage.Allocated {
+		t.Errorf("wrong memory. expected=%d, got=%d", usage.Allocated, usage.Memory)
+	}
+	if usage.Depth != 4 {
+		t.Errorf("wrong depth. expected=4, got=%d", usage.Depth)
+	}
+	if usage.Steps == 0 {
+		t.Errorf("no steps counted")
+	}
+}
+
+// testPeakMemory checks that the memory reported is the peak of the bytes in
+// use, and not the total allocated
+func testPeakMemory(t *testing.T, engine Engine) {
+	// an array of 100 strings of 16 bytes stays in use, 1000 more are discarded
+	input := `let keep = []; for (i in range(100)) { keep = push(keep, "abcdefgh" + "abcdefgh") }; for (i in range(1000)) { let s = "abcdefgh" + "abcdefgh" }`
+	_, usage, err := engine(context.Background(), parse(t, input), object.Limits{})
+	if err != nil {
+		t.Fatalf("%q: unexpected error: %s", input, err)
+	}
+	inUse := int64(24 + 100*16 + 100*(16+16))
+	if usage.Memory < inUse || usage.Memory > 2*inUse+4096 {
+		t.Errorf("wrong memory. expected between %d and %d, got=%d", inUse, 2*inUse+4096, usage.Memory)
+	}
+}
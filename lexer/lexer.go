@@ -1,71 +1,167 @@
 package lexer
 
-import "github.com/juandspy/monkey-lang/token"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/juandspy/monkey-lang/token"
+)
 
 type Lexer struct {
 	input        string
-	position     int  // current position in input (points to current char)
-	readPosition int  // current reading position in input (after current char)
-	ch           byte // current char under examination.
-	// As ch is a byte, we can work just with ASCII. This way we keep things simple.
-	// TODO: support Unicode and emojis by using `rune` not byte
+	filename     string // optional, only used to report positions
+	position     int    // current position in input (byte offset of the current char)
+	readPosition int    // current reading position in input (byte offset after current char)
+	ch           rune   // current char under examination, decoded from UTF-8
+	line         int    // line of the current char, starting at 1
+	column       int    // column of the current char in runes, starting at 1
+
+	keepComments bool            // whether to attach the comments to the tokens
+	comments     []token.Comment // comments read since the last token
+
+	errors []*Error // errors found while reading ILLEGAL tokens
+}
+
+// Error explains why a token is ILLEGAL
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+// Error returns the message along with the position, like
+// `script.mk:1:5: unterminated string literal`
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Message
 }
 
 // New returns a pointer to a Lexer with its properties already initialized
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile is like New but it also stores the filename the input was read from,
+// so that the tokens positions look like `script.mk:12:7`
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
+	if strings.HasPrefix(input, "#!") {
+		// skip the shebang line of the scripts run as executables
+		l.skipLineComment()
+	}
 	return l
 }
 
 // readChar updates the Lexer properties so that:
 // - `ch` is set to the character in the position `readPosition`
 // - `position“ is set to the readPosition
-// - `readPosition“ is incremented by 1
+// - `readPosition“ is incremented by the number of bytes of the character
 func (l *Lexer) readChar() {
+	// Keep track of the line and column of the character we are about to read
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
+	l.column += 1
+
 	if l.readPosition >= len(l.input) {
 		// Check whether we have reached the end of input.
 		// If that’s the case it sets l.ch to 0, which is the ASCII code for the
 		// "NUL" character (http://www.csc.villanova.edu/~tway/resources/ascii-table.html).
 		// It will mean “we haven’t read anything yet” or “end of file”.
+		// The positions stay right after the input no matter how many times we
+		// read past the end, so that it's always safe to slice the input with them
 		l.ch = 0
-	} else {
-		// Set the l.ch to the next character to read
-		l.ch = l.input[l.readPosition]
+		l.position = len(l.input)
+		l.readPosition = len(l.input) + 1
+		return
 	}
+	// Set the l.ch to the next character to read. Invalid UTF-8 is decoded
+	// as utf8.RuneError, which ends up being an ILLEGAL token
+	ch, width := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	l.ch = ch
 	// Update the position to the character just read
 	l.position = l.readPosition
-	// Increment the read position by 1 so that next time this function is called
-	// we read the next character
-	l.readPosition += 1
+	// Increment the read position by the width of the character so that next
+	// time this function is called we read the next character
+	l.readPosition += width
 }
 
 // peekChar is like readChar but it doesn't increment the l.position nor l.readPosition.
 // It returns the character at the l.readPosition
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
 
+// Errors returns the errors found so far. Each ILLEGAL token returned by
+// NextToken comes with an error explaining it.
+func (l *Lexer) Errors() []*Error {
+	return l.errors
+}
+
+// addError stores an error found at the given position
+func (l *Lexer) addError(pos token.Position, format string, a ...interface{}) {
+	err := &Error{Pos: pos, Message: fmt.Sprintf(format, a...)}
+	l.errors = append(l.errors, err)
+}
+
+// KeepComments makes the lexer retain the comments as trivia of the token that
+// follows them (see token.Token.Comments). By default they are discarded.
+func (l *Lexer) KeepComments(keep bool) {
+	l.keepComments = keep
+}
+
+// NextToken returns the next token in the input, including its position and
+// the comments before it (if they are kept)
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
+	pos, ok := l.skipWhitespace()
+	if ok {
+		pos = l.currentPosition()
+		tok = l.readToken(pos)
+	} else {
+		// the rest of the input is an unterminated comment
+		l.addError(pos, "unterminated comment")
+		tok = token.Token{Type: token.ILLEGAL, Literal: l.input[pos.Offset:]}
+	}
+	tok.Pos = pos
+	tok.Comments = l.comments
+	l.comments = nil
+	return tok
+}
 
-	l.skipWhitespace()
+// illegalCharacter returns an ILLEGAL token for the current character, which
+// can't start any token, and reports it
+func (l *Lexer) illegalCharacter(pos token.Position) token.Token {
+	l.addError(pos, "illegal character %q", l.ch)
+	return newToken(token.ILLEGAL, l.ch)
+}
+
+// makeTwoCharToken returns a token made of the current and the next character,
+// like `==`. The next character is consumed so that it's not read again.
+func (l *Lexer) makeTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
+// readToken returns the token that starts at the current character, which is
+// in the given position
+func (l *Lexer) readToken(pos token.Position) token.Token {
+	var tok token.Token
 
 	// Get the token from the current character under examination
 	switch l.ch {
 	case '=':
-		// TODO: unify this case with the "!" case to avoid duplication of code. Create a "makeTwoCharToken" method
 		if l.peekChar() == '=' {
 			// if the next char is also a "=", then the token is "EQ"
-			ch := l.ch // store the current character before we move the l.readPosition
-			// increment the l.readPosition so that the next "=" is not read again
-			l.readChar()
-			// use the new l.readPosition to get the next character and join with the current
-			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch)}
+			tok = l.makeTwoCharToken(token.EQ)
 		} else {
 			// otherwise the token is "ASSIGN"
 			tok = newToken(token.ASSIGN, l.ch)
@@ -79,36 +175,100 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.makeTwoCharToken(token.PLUS_ASSIGN)
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.makeTwoCharToken(token.MINUS_ASSIGN)
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' {
 			// if the next char is a "=", then the token is "NOT_EQ"
-			ch := l.ch // store the current character before we move the l.readPosition
-			// increment the l.readPosition so that the next "=" is not read again
-			l.readChar()
-			// use the new l.readPosition to get the next character and join with the current
-			tok = token.Token{Type: token.NOT_EQ, Literal: string(ch) + string(l.ch)}
+			tok = l.makeTwoCharToken(token.NOT_EQ)
 		} else {
 			// otherwise the token is "BANG"
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.makeTwoCharToken(token.ASTERISK_ASSIGN)
+		} else if l.peekChar() == '*' {
+			tok = l.makeTwoCharToken(token.POWER)
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.makeTwoCharToken(token.SLASH_ASSIGN)
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.makeTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.AMPERSAND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.makeTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
+	case '^':
+		tok = newToken(token.CARET, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.makeTwoCharToken(token.LT_EQ)
+		case '<':
+			tok = l.makeTwoCharToken(token.SHL)
+		default:
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.makeTwoCharToken(token.GT_EQ)
+		case '>':
+			tok = l.makeTwoCharToken(token.SHR)
+		default:
+			tok = newToken(token.GT, l.ch)
+		}
 	case '"':
+		literal, ok := l.readString(pos)
+		if !ok {
+			if l.ch == '"' {
+				l.readChar() // the string is terminated, but it has invalid escapes
+			}
+			tok.Type = token.ILLEGAL
+			tok.Literal = l.input[pos.Offset:l.position]
+			return tok
+		}
+		tok.Type = token.STRING
+		tok.Literal = literal
+	case '`':
+		literal, ok := l.readRawString(pos)
+		if !ok {
+			tok.Type = token.ILLEGAL
+			tok.Literal = l.input[pos.Offset:l.position]
+			return tok
+		}
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok.Literal = literal
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -125,14 +285,19 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok // early exiting as readIdentifier already calls readChar
-		} else if isDigit(l.ch) {
-			// if it's a digit then read it as an integer (INT)
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+		} else if isDigit(l.ch) || l.ch == '.' && isDigit(l.peekChar()) {
+			// if it's a digit then read it as an integer (INT) or a float (FLOAT)
+			tok.Literal, tok.Type = l.readNumber()
 			return tok
+		} else if strings.HasPrefix(l.input[l.position:], "...") {
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+			l.readChar()
+			l.readChar()
+		} else if l.ch == '.' {
+			tok = newToken(token.DOT, l.ch)
 		} else {
 			// if it's not a letter then we don't know how to handle
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = l.illegalCharacter(pos)
 		}
 	}
 
@@ -140,8 +305,18 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// currentPosition returns the position of the character under examination
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Line:     l.line,
+		Column:   l.column,
+		Offset:   l.position,
+	}
+}
+
 // newToken returns a Token initialized with it's type and literal
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
@@ -157,44 +332,216 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-// readNumber reads in an identifier and advances our lexer’s positions until
-// it encounters a non-digit-character
-func (l *Lexer) readNumber() string {
+// readNumber reads in a number and advances our lexer’s positions until
+// it encounters a character that can't be part of it. It returns a FLOAT
+// if the number has a fractional part (`1.5`, `.5`) or an exponent (`1e-3`),
+// otherwise an INT.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	tokenType := token.TokenType(token.INT)
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar() // escape the '.'
+		l.readDigits()
+	}
+	if (l.ch == 'e' || l.ch == 'E') && l.isExponentStart() {
+		tokenType = token.FLOAT
+		l.readChar() // escape the 'e'
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		l.readDigits()
+	}
+	return l.input[position:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+}
+
+// isExponentStart checks whether the 'e' under examination is followed by the
+// exponent digits, optionally signed, so that `1else` is not read as a float
+func (l *Lexer) isExponentStart() bool {
+	next := l.peekChar()
+	if isDigit(next) {
+		return true
+	}
+	if next != '+' && next != '-' {
+		return false
+	}
+	afterSign := l.readPosition + 1 // the sign is always 1 byte long
+	return afterSign < len(l.input) && isDigit(rune(l.input[afterSign]))
 }
 
 // readString reads in a string and advances our lexer’s positions until
-// it encounters the end of the string
-func (l *Lexer) readString() string {
-	position := l.position + 1 // escape the first '"'
+// it encounters the end of the string. It returns the string with the escape
+// sequences (`\n`, `\t`, `\r`, `\\`, `\"` and `\u{1F435}`) already decoded.
+// If the string is not valid it returns false and stores the error.
+func (l *Lexer) readString(start token.Position) (string, bool) {
+	var out strings.Builder
+	valid := true
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch l.ch {
+		case '"':
+			return out.String(), valid
+		case 0:
+			if l.readPosition > len(l.input) {
+				l.addError(start, "unterminated string literal")
+				return "", false
+			}
+			out.WriteRune(l.ch)
+		case '\\':
+			if !l.readEscapeSequence(&out) {
+				valid = false
+			}
+		default:
+			out.WriteRune(l.ch)
+		}
+	}
+}
+
+// readEscapeSequence decodes the escape sequence starting at the current '\\'
+func (l *Lexer) readEscapeSequence(out *strings.Builder) bool {
+	pos := l.currentPosition()
+	l.readChar() // escape the '\\'
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '\\', '"':
+		out.WriteRune(l.ch)
+	case 'u':
+		return l.readUnicodeEscape(pos, out)
+	case 0:
+		// end of input, the unterminated string error is reported by readString
+	default:
+		l.addError(pos, "unknown escape sequence \\%c", l.ch)
+		return false
+	}
+	return true
+}
+
+// readUnicodeEscape decodes an escape like `\u{1F435}`, where the current
+// character is the 'u'
+func (l *Lexer) readUnicodeEscape(pos token.Position, out *strings.Builder) bool {
+	if l.peekChar() != '{' {
+		l.addError(pos, "invalid unicode escape, expected \\u{...}")
+		return false
+	}
+	l.readChar() // escape the 'u'
+	start := l.readPosition
+	for isHexDigit(l.peekChar()) {
+		l.readChar()
+	}
+	digits := l.input[start:l.readPosition]
+	if l.peekChar() != '}' || len(digits) == 0 || len(digits) > 6 {
+		l.addError(pos, "invalid unicode escape, expected \\u{...}")
+		return false
+	}
+	l.readChar() // escape the last digit, the current char is now '}'
+
+	value, _ := strconv.ParseUint(digits, 16, 32)
+	if !utf8.ValidRune(rune(value)) {
+		l.addError(pos, "invalid unicode code point \\u{%s}", digits)
+		return false
+	}
+	out.WriteRune(rune(value))
+	return true
+}
+
+// readRawString reads in a string enclosed in backticks. It can span multiple
+// lines and the escape sequences are not decoded.
+func (l *Lexer) readRawString(start token.Position) (string, bool) {
+	position := l.position + 1 // escape the first '`'
+	for {
+		l.readChar()
+		if l.ch == '`' {
+			return l.input[position:l.position], true
+		}
+		if l.ch == 0 && l.readPosition > len(l.input) {
+			l.addError(start, "unterminated string literal")
+			return "", false
 		}
 	}
-	return l.input[position:l.position]
 }
 
-// isLetter returns true if the input is a letter or underscore
-// (letting us use "foo_bar" for example)
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// isLetter returns true if the input is a Unicode letter or underscore
+// (letting us use "foo_bar" or "años" for example)
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-// isDigit returns true if the input is a digit
-func isDigit(ch byte) bool {
-	// TODO: Add support for floats
+// isDigit returns true if the input is an ASCII digit
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-// skipWhitespace calls readChar until it encounters a non whitespace character
-func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+// isHexDigit returns true if the input is an hexadecimal digit
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+// skipWhitespace calls readChar until it encounters a non whitespace character.
+// Comments are skipped too, as they are whitespace for the parser. It returns
+// false if the input ends inside a block comment, which starts at the returned
+// position.
+func (l *Lexer) skipWhitespace() (token.Position, bool) {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/':
+			pos := l.currentPosition()
+			l.skipLineComment()
+			l.readComment(pos)
+		case l.ch == '/' && l.peekChar() == '*':
+			pos := l.currentPosition()
+			if !l.skipBlockComment() {
+				return pos, false
+			}
+			l.readComment(pos)
+		default:
+			return token.Position{}, true
+		}
+	}
+}
+
+// readComment retains the comment that starts at pos and ends before the
+// current character, if needed
+func (l *Lexer) readComment(pos token.Position) {
+	if l.keepComments {
+		text := l.input[pos.Offset:l.position]
+		l.comments = append(l.comments, token.Comment{Text: text, Pos: pos})
+	}
+}
+
+// skipLineComment advances until the end of the line, which is not part of the comment
+func (l *Lexer) skipLineComment() {
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+}
+
+// skipBlockComment advances until the character after the closing `*/`. It
+// returns false if the input ends before it.
+func (l *Lexer) skipBlockComment() bool {
+	l.readChar() // escape the '/'
+	l.readChar() // escape the '*'
+	for !(l.ch == '*' && l.peekChar() == '/') {
+		if l.ch == 0 {
+			return false
+		}
 		l.readChar()
 	}
+	l.readChar() // escape the '*'
+	l.readChar() // escape the '/'
+	return true
 }
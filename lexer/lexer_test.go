@@ -14,7 +14,7 @@ let add = fn(x, y) {
 };
 let result = add(five, ten);
 
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -30,6 +30,12 @@ if (5 < 10) {
 "foo bar"
 [1, 2];
 {"foo": "bar"}
+x += 1 -= 2 *= 3 /= 4;
+while for in break continue
+a && b || c
+1 <= 2 >= 3 % 4 ** 5 & 6 | 7 ^ ~8 << 9 >> 0
+...rest .5 math.sqrt
+throw try catch finally
 `
 
 	tests := []struct {
@@ -122,6 +128,290 @@ if (5 < 10) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.SEMICOLON, ";"},
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.IN, "in"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.INT, "1"},
+		{token.LT_EQ, "<="},
+		{token.INT, "2"},
+		{token.GT_EQ, ">="},
+		{token.INT, "3"},
+		{token.PERCENT, "%"},
+		{token.INT, "4"},
+		{token.POWER, "**"},
+		{token.INT, "5"},
+		{token.AMPERSAND, "&"},
+		{token.INT, "6"},
+		{token.PIPE, "|"},
+		{token.INT, "7"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.INT, "8"},
+		{token.SHL, "<<"},
+		{token.INT, "9"},
+		{token.SHR, ">>"},
+		{token.INT, "0"},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "rest"},
+		{token.FLOAT, ".5"},
+		{token.IDENT, "math"},
+		{token.DOT, "."},
+		{token.IDENT, "sqrt"},
+		{token.THROW, "throw"},
+		{token.TRY, "try"},
+		{token.CATCH, "catch"},
+		{token.FINALLY, "finally"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x + "foo";`
+
+	tests := []struct {
+		expectedLiteral string
+		expectedPos     string
+		expectedOffset  int
+	}{
+		{"let", "script.mk:1:1", 0},
+		{"x", "script.mk:1:5", 4},
+		{"=", "script.mk:1:7", 6},
+		{"5", "script.mk:1:9", 8},
+		{";", "script.mk:1:10", 9},
+		{"x", "script.mk:2:3", 13},
+		{"+", "script.mk:2:5", 15},
+		{"foo", "script.mk:2:7", 17},
+		{";", "script.mk:2:12", 22},
+		{"", "script.mk:2:13", 23},
+	}
+
+	l := NewFile("script.mk", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%q, got=%q",
+				i, tt.expectedPos, tok.Pos.String())
+		}
+		if tok.Pos.Offset != tt.expectedOffset {
+			t.Fatalf("tests[%d] - offset wrong. expected=%d, got=%d",
+				i, tt.expectedOffset, tok.Pos.Offset)
+		}
+	}
+}
+
+func TestShebang(t *testing.T) {
+	input := "#!/usr/bin/env monkey\nlet x = 1;"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  string
+	}{
+		{token.LET, "2:1"},
+		{token.IDENT, "2:5"},
+		{token.ASSIGN, "2:7"},
+		{token.INT, "2:9"},
+		{token.SEMICOLON, "2:10"},
+		{token.EOF, "2:11"},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%q, got=%q",
+				i, tt.expectedPos, tok.Pos.String())
+		}
+	}
+}
+
+func TestNextTokenUnicode(t *testing.T) {
+	input := `let años = "héllo 🐵";
+años + €`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedPos     string
+	}{
+		{token.LET, "let", "1:1"},
+		{token.IDENT, "años", "1:5"},
+		{token.ASSIGN, "=", "1:10"},
+		{token.STRING, "héllo 🐵", "1:12"},
+		{token.SEMICOLON, ";", "1:21"},
+		{token.IDENT, "años", "2:1"},
+		{token.PLUS, "+", "2:6"},
+		{token.ILLEGAL, "€", "2:8"},
+		{token.EOF, "", "2:9"},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%q, got=%q",
+				i, tt.expectedPos, tok.Pos.String())
+		}
+	}
+}
+
+func TestNextTokenNumbers(t *testing.T) {
+	input := `1 1.5 .5 10.25 1e3 1e-3 2.5E+10 1else 3.foo`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "1"},
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, ".5"},
+		{token.FLOAT, "10.25"},
+		{token.FLOAT, "1e3"},
+		{token.FLOAT, "1e-3"},
+		{token.FLOAT, "2.5E+10"},
+		{token.INT, "1"},
+		{token.ELSE, "else"},
+		{token.INT, "3"},
+		{token.DOT, "."},
+		{token.IDENT, "foo"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextTokenComments(t *testing.T) {
+	input := `// a line comment
+let x = 10 / 2; // trailing
+/* a block
+   comment */ x /* inline */ * 2
+/* unterminated`
+
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLiteral  string
+		expectedComments []string
+	}{
+		{token.LET, "let", []string{"// a line comment"}},
+		{token.IDENT, "x", nil},
+		{token.ASSIGN, "=", nil},
+		{token.INT, "10", nil},
+		{token.SLASH, "/", nil},
+		{token.INT, "2", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []string{"// trailing", "/* a block\n   comment */"}},
+		{token.ASTERISK, "*", []string{"/* inline */"}},
+		{token.INT, "2", nil},
+		{token.ILLEGAL, "/* unterminated", nil},
+		{token.EOF, "", nil},
+	}
+
+	for _, keep := range []bool{false, true} {
+		l := New(input)
+		l.KeepComments(keep)
+		for i, tt := range tests {
+			tok := l.NextToken()
+
+			if tok.Type != tt.expectedType {
+				t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+					i, tt.expectedType, tok.Type)
+			}
+			if tok.Literal != tt.expectedLiteral {
+				t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+					i, tt.expectedLiteral, tok.Literal)
+			}
+
+			expectedComments := tt.expectedComments
+			if !keep {
+				expectedComments = nil
+			}
+			if len(tok.Comments) != len(expectedComments) {
+				t.Fatalf("tests[%d] - wrong number of comments. expected=%d, got=%d",
+					i, len(expectedComments), len(tok.Comments))
+			}
+			for j, comment := range expectedComments {
+				if tok.Comments[j].Text != comment {
+					t.Fatalf("tests[%d] - comment wrong. expected=%q, got=%q",
+						i, comment, tok.Comments[j].Text)
+				}
+			}
+		}
+	}
+}
+
+func TestNextTokenStrings(t *testing.T) {
+	input := "\"say \\\"hi\\\"\\n\" \"tab\\there\\\\\" \"\\u{1F435}\\u{e9}\" `raw \\n\nmulti-line` \"\""
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "say \"hi\"\n"},
+		{token.STRING, "tab\there\\"},
+		{token.STRING, "🐵é"},
+		{token.STRING, "raw \\n\nmulti-line"},
+		{token.STRING, ""},
 		{token.EOF, ""},
 	}
 
@@ -138,4 +428,48 @@ if (5 < 10) {
 				i, tt.expectedLiteral, tok.Literal)
 		}
 	}
+	if len(l.Errors()) != 0 {
+		t.Fatalf("unexpected lexer errors: %q", l.Errors())
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedError   string
+	}{
+		{`let s = "foo`, `"foo`, "1:9: unterminated string literal"},
+		{"let s = `foo\nbar", "`foo\nbar", "1:9: unterminated string literal"},
+		{`"foo\`, `"foo\`, "1:1: unterminated string literal"},
+		{`"a\qb"`, `"a\qb"`, `1:3: unknown escape sequence \q`},
+		{`"\u{110000}"`, `"\u{110000}"`, `1:2: invalid unicode code point \u{110000}`},
+		{`"\u00e9"`, `"\u00e9"`, `1:2: invalid unicode escape, expected \u{...}`},
+		{"1 € 2", "€", `1:3: illegal character '€'`},
+		{"1 /* foo", "/* foo", "1:3: unterminated comment"},
+		{"/* a */ 1 /* b /* c\n*", "/* b /* c\n*", "1:11: unterminated comment"},
+		{"/*/", "/*/", "1:1: unterminated comment"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		var illegal *token.Token
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			if tok.Type == token.ILLEGAL {
+				illegal = &tok
+				break
+			}
+		}
+		if illegal == nil {
+			t.Fatalf("tests[%d] - no ILLEGAL token found", i)
+		}
+		if illegal.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, illegal.Literal)
+		}
+		if len(l.Errors()) != 1 || l.Errors()[0].Error() != tt.expectedError {
+			t.Fatalf("tests[%d] - wrong errors. expected=%q, got=%q",
+				i, tt.expectedError, l.Errors())
+		}
+	}
 }
@@ -0,0 +1,318 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/juandspy/monkey-lang/ast"
+	"github.com/juandspy/monkey-lang/lexer"
+	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/parser"
+)
+
+// document is an open text document. It's parsed and resolved every time it
+// changes, even if it has errors, so that the features keep working while
+// the code is being written.
+type document struct {
+	uri        string
+	text       string
+	lines      []int // offsets where the lines start
+	builtins   *object.Registry
+	errors     []*parser.Error
+	resolution *Resolution
+}
+
+func newDocument(uri, text string, builtins *object.Registry) *document {
+	d := &document{uri: uri, text: text, lines: []int{0}, builtins: builtins}
+	for i, ch := range text {
+		if ch == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	p := parser.New(lexer.New(text))
+	program := p.ParseProgram()
+	d.errors = p.Errors()
+	d.resolution = Resolve(program, builtins)
+	return d
+}
+
+// position converts an offset of the text to a position of the protocol
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	return Position{Line: line, Character: utf16Len(d.text[d.lines[line]:offset])}
+}
+
+// offset converts a position of the protocol to an offset of the text. The
+// positions past the end of a line are moved to its end.
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset := d.lines[pos.Line]
+	for character := 0; character < pos.Character && offset < len(d.text); {
+		ch, size := utf8.DecodeRuneInString(d.text[offset:])
+		if ch == '\n' {
+			break
+		}
+		character += len(utf16.Encode([]rune{ch}))
+		offset += size
+	}
+	return offset
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// identRange returns the range of the identifier in the text
+func (d *document) identRange(ident *ast.Identifier) Range {
+	start := ident.Pos().Offset
+	return Range{Start: d.position(start), End: d.position(start + len(ident.Value))}
+}
+
+func (d *document) location(ident *ast.Identifier) Location {
+	return Location{URI: d.uri, Range: d.identRange(ident)}
+}
+
+// identifierAt returns the identifier at the offset, including the one that
+// ends right before it
+func (d *document) identifierAt(offset int) *ast.Identifier {
+	contains := func(ident *ast.Identifier) bool {
+		start := ident.Pos().Offset
+		return start <= offset && offset <= start+len(ident.Value)
+	}
+	for ident := range d.resolution.Identifiers {
+		if contains(ident) {
+			return ident
+		}
+	}
+	for ident := range d.resolution.Builtins {
+		if contains(ident) {
+			return ident
+		}
+	}
+	return nil
+}
+
+// diagnostics returns the syntax errors of the document
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, err := range d.errors {
+		// the error covers the character where it was found
+		start, end := err.Pos.Offset, err.Pos.Offset
+		if end < len(d.text) && d.text[end] != '\n' {
+			_, size := utf8.DecodeRuneInString(d.text[end:])
+			end += size
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    Range{Start: d.position(start), End: d.position(end)},
+			Severity: severityError,
+			Source:   "monkey",
+			Message:  err.Message,
+		})
+	}
+	return diagnostics
+}
+
+// definition returns where the name at the offset is declared
+func (d *document) definition(offset int) (Location, bool) {
+	ident := d.identifierAt(offset)
+	binding, ok := d.resolution.Identifiers[ident]
+	if !ok {
+		return Location{}, false
+	}
+	return d.location(binding.Ident), true
+}
+
+// references returns the identifiers that refer to the same binding as the
+// one at the offset, in the order of the text
+func (d *document) references(offset int, includeDeclaration bool) []Location {
+	locations := []Location{}
+	binding, ok := d.resolution.Identifiers[d.identifierAt(offset)]
+	if !ok {
+		return locations
+	}
+	idents := append([]*ast.Identifier{}, binding.References...)
+	if includeDeclaration {
+		idents = append(idents, binding.Ident)
+	}
+	sort.Slice(idents, func(i, j int) bool { return idents[i].Pos().Offset < idents[j].Pos().Offset })
+	for _, ident := range idents {
+		locations = append(locations, d.location(ident))
+	}
+	return locations
+}
+
+// hover describes the name at the offset, like `let x: INTEGER` or
+// `builtin len/1`
+func (d *document) hover(offset int) (*Hover, bool) {
+	ident := d.identifierAt(offset)
+	if ident == nil {
+		return nil, false
+	}
+	var description string
+	if binding, ok := d.resolution.Identifiers[ident]; ok {
+		description = d.describe(binding)
+	} else {
+		switch builtin := d.resolution.Builtins[ident].(type) {
+		case *object.Hash:
+			description = "namespace " + ident.Value
+		default:
+			description = builtin.Inspect()
+		}
+	}
+	identRange := d.identRange(ident)
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```monkey\n" + description + "\n```"},
+		Range:    &identRange,
+	}, true
+}
+
+func (d *document) describe(binding *Binding) string {
+	switch binding.Kind {
+	case LetBinding:
+		if fn, ok := binding.Value.(*ast.FunctionLiteral); ok {
+			return "let " + binding.Name + " = fn(" + fn.ParametersString() + ")"
+		}
+		if typ := d.inferType(binding.Value, 0); typ != "" {
+			return "let " + binding.Name + ": " + string(typ)
+		}
+		return "let " + binding.Name
+	case ParameterBinding:
+		if binding.Function != nil && binding.Function.Name != "" {
+			return "parameter " + binding.Name + " of " + binding.Function.Name
+		}
+	}
+	return string(binding.Kind) + " " + binding.Name
+}
+
+// maxInferenceDepth limits the chain of bindings followed to infer a type,
+// like in `let a = b; let b = a;`
+const maxInferenceDepth = 16
+
+// inferType returns the type of the value of the expression if it can be
+// known without running it, or "" otherwise
+func (d *document) inferType(expr ast.Expression, depth int) object.ObjectType {
+	if depth > maxInferenceDepth {
+		return ""
+	}
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral:
+		return object.INTEGER_OBJ
+	case *ast.FloatLiteral:
+		return object.FLOAT_OBJ
+	case *ast.StringLiteral:
+		return object.STRING_OBJ
+	case *ast.Boolean:
+		return object.BOOLEAN_OBJ
+	case *ast.ArrayLiteral:
+		return object.ARRAY_OBJ
+	case *ast.HashLiteral:
+		return object.HASH_OBJ
+	case *ast.FunctionLiteral:
+		return object.FUNCTION_OBJ
+	case *ast.PrefixExpression:
+		if expr.Operator == "!" {
+			return object.BOOLEAN_OBJ
+		}
+		return d.inferType(expr.Right, depth+1)
+	case *ast.InfixExpression:
+		switch expr.Operator {
+		case "==", "!=", "<", ">", "<=", ">=", "&&", "||":
+			return object.BOOLEAN_OBJ
+		}
+		left, right := d.inferType(expr.Left, depth+1), d.inferType(expr.Right, depth+1)
+		if left == right && (left == object.INTEGER_OBJ || left == object.FLOAT_OBJ || left == object.STRING_OBJ) {
+			return left
+		}
+		if (left == object.INTEGER_OBJ && right == object.FLOAT_OBJ) ||
+			(left == object.FLOAT_OBJ && right == object.INTEGER_OBJ) {
+			return object.FLOAT_OBJ
+		}
+	case *ast.Identifier:
+		if binding, ok := d.resolution.Identifiers[expr]; ok && binding.Kind == LetBinding {
+			return d.inferType(binding.Value, depth+1)
+		}
+		if builtin, ok := d.resolution.Builtins[expr]; ok {
+			return builtin.Type()
+		}
+	}
+	return ""
+}
+
+// symbols returns the `let` bindings of the document, in the order of the text
+func (d *document) symbols() []SymbolInformation {
+	symbols := []SymbolInformation{}
+	for _, binding := range d.resolution.Bindings {
+		if binding.Kind != LetBinding {
+			continue
+		}
+		symbol := SymbolInformation{Name: binding.Name, Kind: symbolKindVariable, Location: d.location(binding.Ident)}
+		if _, ok := binding.Value.(*ast.FunctionLiteral); ok {
+			symbol.Kind = symbolKindFunction
+		}
+		if binding.Function != nil {
+			symbol.ContainerName = binding.Function.Name
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// completion returns the builtins that can be written at the offset. After
+// the name of a namespace and a dot, like `math.`, they are the functions of
+// the namespace.
+func (d *document) completion(offset int) []CompletionItem {
+	items := []CompletionItem{}
+	start := offset
+	for start > 0 {
+		ch, size := utf8.DecodeLastRuneInString(d.text[:start])
+		if ch != '.' && !isIdentifierRune(ch) {
+			break
+		}
+		start -= size
+	}
+	word := d.text[start:offset]
+
+	if i := strings.LastIndex(word, "."); i >= 0 {
+		namespace, _ := d.builtins.Lookup(word[:i])
+		if hash, ok := namespace.(*object.Hash); ok {
+			for _, pair := range hash.Pairs {
+				if key, ok := pair.Key.(*object.String); ok {
+					items = append(items, CompletionItem{
+						Label: key.Value, Kind: completionKindFunction, Detail: pair.Value.Inspect(),
+					})
+				}
+			}
+		}
+	} else {
+		for _, name := range d.builtins.Names() {
+			builtin, ok := d.builtins.Lookup(name)
+			if name == "" || !ok {
+				continue
+			}
+			if _, ok := builtin.(*object.Hash); ok {
+				items = append(items, CompletionItem{Label: name, Kind: completionKindModule, Detail: "namespace " + name})
+			} else {
+				items = append(items, CompletionItem{Label: name, Kind: completionKindFunction, Detail: builtin.Inspect()})
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+// isIdentifierRune returns true if the rune can be part of an identifier,
+// like the lexer does
+func isIdentifierRune(ch rune) bool {
+	return ch == '_' || unicode.IsLetter(ch) || '0' <= ch && ch <= '9'
+}
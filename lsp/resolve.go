@@ -0,0 +1,224 @@
+package lsp
+
+import (
+	"sort"
+
+	"github.com/juandspy/monkey-lang/ast"
+	"github.com/juandspy/monkey-lang/object"
+)
+
+// BindingKind tells how a name was declared
+type BindingKind string
+
+const (
+	LetBinding       BindingKind = "let"
+	ParameterBinding BindingKind = "parameter"
+	ForBinding       BindingKind = "for variable"
+	CatchBinding     BindingKind = "catch variable"
+)
+
+// Binding is a name declared in a program
+type Binding struct {
+	Name  string
+	Kind  BindingKind
+	Ident *ast.Identifier // where the name is declared
+	// Value is the value of a `let`, nil for the other kinds
+	Value ast.Expression
+	// Function is the function the binding is declared in, nil at the top level
+	Function *ast.FunctionLiteral
+	// References are the identifiers that refer to the binding, in the order
+	// they are found. The declaration is not included.
+	References []*ast.Identifier
+}
+
+// Resolution is the result of resolving the identifiers of a program
+type Resolution struct {
+	// Bindings are the declared names, in the order of the source code
+	Bindings []*Binding
+	// Identifiers maps the declarations and the references to their binding.
+	// The identifiers that are not declared in the program, like the
+	// builtins, are not included.
+	Identifiers map[*ast.Identifier]*Binding
+	// Builtins are the references to builtins and namespaces
+	Builtins map[*ast.Identifier]object.Object
+}
+
+// scope has the bindings visible in a function, or at the top level. Only
+// functions have their own scope: the variables of the blocks, loops and
+// catches are bound in the scope of the function, like the evaluator does.
+type scope struct {
+	outer    *scope
+	function *ast.FunctionLiteral
+	names    map[string]*Binding
+}
+
+func (s *scope) lookup(name string) (*Binding, bool) {
+	for ; s != nil; s = s.outer {
+		if binding, ok := s.names[name]; ok {
+			return binding, true
+		}
+	}
+	return nil, false
+}
+
+type resolver struct {
+	resolution *Resolution
+	builtins   *object.Registry
+	// pending are the functions whose body is resolved once the enclosing
+	// scope is done. They are called after it's defined, so they see every
+	// name bound in it, like `let` functions calling themselves.
+	pending []func()
+}
+
+// Resolve binds the identifiers of the program to their declarations. The
+// names that are not declared are looked up in the builtins. A redeclared name
+// is a new binding for the statements that follow.
+func Resolve(program *ast.Program, builtins *object.Registry) *Resolution {
+	r := &resolver{
+		resolution: &Resolution{
+			Identifiers: map[*ast.Identifier]*Binding{},
+			Builtins:    map[*ast.Identifier]object.Object{},
+		},
+		builtins: builtins,
+	}
+	global := &scope{names: map[string]*Binding{}}
+	for _, stmt := range program.Statements {
+		r.statement(stmt, global)
+	}
+	for len(r.pending) > 0 {
+		next := r.pending[0]
+		r.pending = r.pending[1:]
+		next()
+	}
+	sort.Slice(r.resolution.Bindings, func(i, j int) bool {
+		return r.resolution.Bindings[i].Ident.Pos().Offset < r.resolution.Bindings[j].Ident.Pos().Offset
+	})
+	return r.resolution
+}
+
+func (r *resolver) declare(ident *ast.Identifier, kind BindingKind, value ast.Expression, s *scope) {
+	if ident == nil {
+		return
+	}
+	binding := &Binding{Name: ident.Value, Kind: kind, Ident: ident, Value: value, Function: s.function}
+	s.names[ident.Value] = binding
+	r.resolution.Bindings = append(r.resolution.Bindings, binding)
+	r.resolution.Identifiers[ident] = binding
+}
+
+func (r *resolver) reference(ident *ast.Identifier, s *scope) {
+	if binding, ok := s.lookup(ident.Value); ok {
+		binding.References = append(binding.References, ident)
+		r.resolution.Identifiers[ident] = binding
+		return
+	}
+	if builtin, ok := r.builtins.Lookup(ident.Value); ok && builtin != nil {
+		r.resolution.Builtins[ident] = builtin
+	}
+}
+
+func (r *resolver) statement(stmt ast.Statement, s *scope) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		// the value is resolved first, `let x = x + 1` uses the previous x
+		r.expression(stmt.Value, s)
+		r.declare(stmt.Name, LetBinding, stmt.Value, s)
+	case *ast.AssignStatement:
+		r.expression(stmt.Target, s)
+		r.expression(stmt.Value, s)
+	case *ast.ReturnStatement:
+		r.expression(stmt.ReturnValue, s)
+	case *ast.ThrowStatement:
+		r.expression(stmt.Value, s)
+	case *ast.ExpressionStatement:
+		r.expression(stmt.Expression, s)
+	case *ast.BlockStatement:
+		r.block(stmt, s)
+	}
+}
+
+func (r *resolver) block(block *ast.BlockStatement, s *scope) {
+	if block == nil {
+		return
+	}
+	for _, stmt := range block.Statements {
+		r.statement(stmt, s)
+	}
+}
+
+func (r *resolver) expression(expr ast.Expression, s *scope) {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		if expr != nil {
+			r.reference(expr, s)
+		}
+	case *ast.PrefixExpression:
+		r.expression(expr.Right, s)
+	case *ast.InfixExpression:
+		r.expression(expr.Left, s)
+		r.expression(expr.Right, s)
+	case *ast.IfExpression:
+		r.expression(expr.Condition, s)
+		r.block(expr.Consequence, s)
+		r.block(expr.Alternative, s)
+	case *ast.WhileExpression:
+		r.expression(expr.Condition, s)
+		r.block(expr.Body, s)
+	case *ast.ForExpression:
+		r.expression(expr.Iterable, s)
+		r.declare(expr.Variable, ForBinding, nil, s)
+		r.block(expr.Body, s)
+	case *ast.TryExpression:
+		r.block(expr.Block, s)
+		r.declare(expr.CatchVariable, CatchBinding, nil, s)
+		r.block(expr.Catch, s)
+		r.block(expr.Finally, s)
+	case *ast.FunctionLiteral:
+		r.function(expr, s)
+	case *ast.CallExpression:
+		r.expression(expr.Function, s)
+		for _, arg := range expr.Arguments {
+			r.expression(arg, s)
+		}
+	case *ast.ArrayLiteral:
+		for _, element := range expr.Elements {
+			r.expression(element, s)
+		}
+	case *ast.IndexExpression:
+		r.expression(expr.Left, s)
+		r.expression(expr.Index, s)
+	case *ast.HashLiteral:
+		for _, pair := range sortedPairs(expr) {
+			r.expression(pair[0], s)
+			r.expression(pair[1], s)
+		}
+	}
+}
+
+// function declares the parameters in a new scope and resolves the body once
+// the enclosing scope is done
+func (r *resolver) function(fn *ast.FunctionLiteral, outer *scope) {
+	if fn == nil {
+		return
+	}
+	s := &scope{outer: outer, function: fn, names: map[string]*Binding{}}
+	r.pending = append(r.pending, func() {
+		for i, param := range fn.Parameters {
+			// the defaults can use the previous parameters
+			r.expression(fn.Default(i), s)
+			r.declare(param, ParameterBinding, nil, s)
+		}
+		r.declare(fn.Rest, ParameterBinding, nil, s)
+		r.block(fn.Body, s)
+	})
+}
+
+// sortedPairs returns the pairs of the hash in the order of the source code
+func sortedPairs(hash *ast.HashLiteral) [][2]ast.Expression {
+	pairs := make([][2]ast.Expression, 0, len(hash.Pairs))
+	for key, value := range hash.Pairs {
+		pairs = append(pairs, [2]ast.Expression{key, value})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0].Pos().Offset < pairs[j][0].Pos().Offset })
+	return pairs
+}
@@ -0,0 +1,103 @@
+package lsp
+
+import (
+	"testing"
+
+	"github.com/juandspy/monkey-lang/lexer"
+	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/parser"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		input string
+		// the declaration each identifier resolves to, by the offset of both
+		expected map[int]int
+	}{
+		// a let resolves its value before being declared
+		{"let x = 1; let x = x + 1; x", map[int]int{4: 4, 15: 15, 19: 4, 26: 15}},
+		// parameters and the default values
+		{"fn(a, b = a) { a + b }", map[int]int{3: 3, 6: 6, 10: 3, 15: 3, 19: 6}},
+		// the rest parameter
+		{"fn(...rest) { rest }", map[int]int{6: 6, 14: 6}},
+		// the functions see the bindings declared after them, like themselves
+		{"let f = fn() { f(); g }; let g = 1;", map[int]int{4: 4, 15: 4, 20: 29, 29: 29}},
+		// the inner functions see the parameters of the outer ones
+		{"fn(a) { fn(b) { a + b } }", map[int]int{3: 3, 11: 11, 16: 3, 20: 11}},
+		// the parameters shadow the outer bindings
+		{"let a = 1; fn(a) { a }; a", map[int]int{4: 4, 14: 14, 19: 14, 24: 4}},
+		// the blocks don't have their own scope
+		{"if (true) { let y = 1; }; y", map[int]int{16: 16, 26: 16}},
+		{"for (i in [1]) { i }; i", map[int]int{5: 5, 17: 5, 22: 5}},
+		{"try { 1 } catch (e) { e }; e", map[int]int{17: 17, 22: 17, 27: 17}},
+		// assignments are references
+		{"let n = 0; n += 1;", map[int]int{4: 4, 11: 4}},
+		{"let h = {}; h[\"k\"] = 1; {h: h}", map[int]int{4: 4, 12: 4, 25: 4, 28: 4}},
+	}
+	for _, tt := range tests {
+		res := resolve(t, tt.input)
+		found := map[int]int{}
+		for ident, binding := range res.Identifiers {
+			found[ident.Pos().Offset] = binding.Ident.Pos().Offset
+		}
+		if len(found) != len(tt.expected) {
+			t.Errorf("wrong identifiers for %q. expected=%v, got=%v", tt.input, tt.expected, found)
+			continue
+		}
+		for offset, declaration := range tt.expected {
+			if found[offset] != declaration {
+				t.Errorf("wrong declaration of the identifier at %d in %q. expected=%d, got=%d",
+					offset, tt.input, declaration, found[offset])
+			}
+		}
+	}
+}
+
+func TestResolveBindings(t *testing.T) {
+	res := resolve(t, "let add = fn(a) { let b = a; b }; for (x in []) { x }; puts(add(1)); math.sqrt(undefined)")
+
+	expected := []struct {
+		name       string
+		kind       BindingKind
+		function   string
+		references int
+	}{
+		{"add", LetBinding, "", 1},
+		{"a", ParameterBinding, "add", 1},
+		{"b", LetBinding, "add", 1},
+		{"x", ForBinding, "", 1},
+	}
+	if len(res.Bindings) != len(expected) {
+		t.Fatalf("wrong number of bindings. want=%d, got=%d", len(expected), len(res.Bindings))
+	}
+	for i, want := range expected {
+		binding := res.Bindings[i]
+		function := ""
+		if binding.Function != nil {
+			function = binding.Function.Name
+		}
+		if binding.Name != want.name || binding.Kind != want.kind || function != want.function ||
+			len(binding.References) != want.references {
+			t.Errorf("binding %d wrong. want=%+v, got=%s %s in %q with %d references",
+				i, want, binding.Kind, binding.Name, function, len(binding.References))
+		}
+	}
+
+	builtins := map[string]bool{}
+	for ident := range res.Builtins {
+		builtins[ident.Value] = true
+	}
+	if len(builtins) != 2 || !builtins["puts"] || !builtins["math"] {
+		t.Errorf("wrong builtins. want=[math puts], got=%v", builtins)
+	}
+}
+
+func resolve(t *testing.T, input string) *Resolution {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return Resolve(program, object.NewRegistry())
+}
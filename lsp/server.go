@@ -0,0 +1,286 @@
+// Package lsp is a language server for Monkey. It speaks the Language Server
+// Protocol, so that editors can show the syntax errors of the .mk files, go to
+// the definition of a name, find its references, describe it on hover, list
+// the bindings of a file and complete the builtins.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+
+	"github.com/juandspy/monkey-lang/object"
+)
+
+// Server is a language server reading the messages of the client from a
+// stream and writing its responses to another one, usually the stdin and the
+// stdout of the process
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	builtins  *object.Registry
+	documents map[string]*document // the open documents, by URI
+
+	initialized bool // the client sent `initialize`
+	shutdown    bool // the client sent `shutdown`, only `exit` is expected
+
+	err error // the error writing a notification, which stops the server
+}
+
+// NewServer returns a server that knows the standard builtins
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		builtins:  object.NewRegistry(),
+		documents: map[string]*document{},
+	}
+}
+
+// errExitWithoutShutdown is returned by Run if the client exits without asking
+// the server to shut down first, which the protocol considers an error
+var errExitWithoutShutdown = errors.New("exit without shutdown")
+
+// Run handles the messages until the client sends `exit` or closes the input
+func (s *Server) Run() error {
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return errExitWithoutShutdown
+		}
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			// the content is not valid JSON
+			if err := s.respond(nil, nil, &responseError{Code: codeParseError, Message: "invalid JSON"}); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+
+		result, respErr := s.handle(msg)
+		if s.err != nil {
+			return s.err
+		}
+		if msg.ID == nil {
+			continue // notifications have no response
+		}
+		if err := s.respond(msg.ID, result, respErr); err != nil {
+			return err
+		}
+	}
+}
+
+// handle runs the method of the message and returns its result
+func (s *Server) handle(msg *message) (interface{}, *responseError) {
+	if !s.initialized && msg.Method != "initialize" {
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "the server is not initialized"}
+	}
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server is shut down"}
+	}
+
+	switch msg.Method {
+	case "initialize":
+		s.initialized = true
+		result := InitializeResult{Capabilities: ServerCapabilities{
+			TextDocumentSync:       textDocumentSyncFull,
+			DefinitionProvider:     true,
+			ReferencesProvider:     true,
+			HoverProvider:          true,
+			DocumentSymbolProvider: true,
+			CompletionProvider:     CompletionOptions{TriggerCharacters: []string{"."}},
+		}}
+		result.ServerInfo.Name = "monkey"
+		return result, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.open(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		// the last change has the whole text, as the sync is full
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		s.open(params.TextDocument.URI, text)
+		return nil, nil
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.documents, params.TextDocument.URI)
+		// the diagnostics of a closed document are removed
+		s.notify("textDocument/publishDiagnostics",
+			PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		return nil, nil
+
+	case "textDocument/definition":
+		doc, offset, err := s.position(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		if location, ok := doc.definition(offset); ok {
+			return location, nil
+		}
+		return nil, nil
+	case "textDocument/references":
+		var params ReferenceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		doc, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return doc.references(doc.offset(params.Position), params.Context.IncludeDeclaration), nil
+	case "textDocument/hover":
+		doc, offset, err := s.position(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		if hover, ok := doc.hover(offset); ok {
+			return hover, nil
+		}
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		doc, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return doc.symbols(), nil
+	case "textDocument/completion":
+		doc, offset, err := s.position(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return doc.completion(offset), nil
+	}
+
+	if msg.ID == nil {
+		return nil, nil // unknown notifications, like `initialized`, are ignored
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// open parses the text of the document and publishes its diagnostics
+func (s *Server) open(uri, text string) {
+	doc := newDocument(uri, text, s.builtins)
+	s.documents[uri] = doc
+	s.notify("textDocument/publishDiagnostics",
+		PublishDiagnosticsParams{URI: uri, Diagnostics: doc.diagnostics()})
+}
+
+func (s *Server) document(uri string) (*document, *responseError) {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: "unknown document: " + uri}
+	}
+	return doc, nil
+}
+
+// position returns the document and the offset of the position of the
+// parameters of a request
+func (s *Server) position(raw json.RawMessage) (*document, int, *responseError) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, 0, invalidParams(err)
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, doc.offset(params.Position), nil
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
+
+// read returns the next message. The message is nil if its content is not
+// valid JSON, as the server can go on with the next one.
+func (s *Server) read() (*message, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %q", header.Get("Content-Length"))
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(s.in, content); err != nil {
+		return nil, err
+	}
+
+	var msg message
+	if err := json.Unmarshal(content, &msg); err != nil {
+		return nil, nil
+	}
+	return &msg, nil
+}
+
+func (s *Server) respond(id *json.RawMessage, result interface{}, respErr *responseError) error {
+	msg := message{JSONRPC: "2.0", ID: id, Error: respErr}
+	if id == nil {
+		null := json.RawMessage("null")
+		msg.ID = &null
+	}
+	if respErr == nil {
+		content, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		msg.Result = content
+	}
+	return s.write(msg)
+}
+
+// notify sends a notification to the client. The errors are kept to be
+// returned by Run, as the notifications are sent while handling other messages.
+func (s *Server) notify(method string, params interface{}) {
+	content, err := json.Marshal(params)
+	if err == nil {
+		err = s.write(message{JSONRPC: "2.0", Method: method, Params: content})
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *Server) write(msg message) error {
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	return err
+}
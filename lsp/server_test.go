@@ -0,0 +1,307 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/juandspy/monkey-lang/object"
+)
+
+const testURI = "file:///test.mk"
+
+const testProgram = `let add = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let x = add(1, 2) * 2;
+puts(x, math.sqrt(4));
+`
+
+// request returns a framed message of the protocol. The ones without id are
+// notifications.
+func request(id int, method string, params interface{}) string {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id != 0 {
+		msg["id"] = id
+	}
+	content, _ := json.Marshal(msg)
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func textPosition(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+		"position":     map[string]int{"line": line, "character": character},
+	}
+}
+
+// runServer runs a server with the messages and returns the ones it sent
+func runServer(t *testing.T, messages ...string) ([]message, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := NewServer(strings.NewReader(strings.Join(messages, "")), &out).Run()
+
+	var sent []message
+	reader := NewServer(&out, nil)
+	for {
+		msg, readErr := reader.read()
+		if readErr != nil {
+			break
+		}
+		sent = append(sent, *msg)
+	}
+	return sent, err
+}
+
+// response returns the result of the response to the request with the id
+func response(t *testing.T, sent []message, id int, result interface{}) {
+	t.Helper()
+	for _, msg := range sent {
+		if msg.ID != nil && string(*msg.ID) == fmt.Sprint(id) {
+			if msg.Error != nil {
+				t.Fatalf("request %d failed: %s", id, msg.Error.Message)
+			}
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				t.Fatalf("invalid result of request %d: %s", id, err)
+			}
+			return
+		}
+	}
+	t.Fatalf("no response to request %d", id)
+}
+
+func TestServer(t *testing.T) {
+	sent, err := runServer(t,
+		request(1, "initialize", map[string]interface{}{}),
+		request(0, "initialized", map[string]interface{}{}),
+		request(0, "textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": testURI, "languageId": "monkey", "version": 1, "text": testProgram},
+		}),
+		request(2, "textDocument/definition", textPosition(2, 3)),
+		request(3, "textDocument/references", map[string]interface{}{
+			"textDocument": map[string]string{"uri": testURI},
+			"position":     map[string]int{"line": 0, "character": 13},
+			"context":      map[string]bool{"includeDeclaration": true},
+		}),
+		request(4, "textDocument/hover", textPosition(4, 5)),
+		request(5, "textDocument/hover", textPosition(5, 1)),
+		request(6, "textDocument/hover", textPosition(5, 9)),
+		request(7, "textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": testURI}}),
+		request(8, "textDocument/completion", textPosition(5, 13)),
+		request(9, "textDocument/definition", textPosition(3, 0)),
+		request(10, "shutdown", nil),
+		request(0, "exit", nil),
+	)
+	if err != nil {
+		t.Fatalf("the server failed: %s", err)
+	}
+
+	var initialize InitializeResult
+	response(t, sent, 1, &initialize)
+	if !initialize.Capabilities.DefinitionProvider || initialize.Capabilities.TextDocumentSync != textDocumentSyncFull {
+		t.Errorf("wrong capabilities: %+v", initialize.Capabilities)
+	}
+
+	var definition Location
+	response(t, sent, 2, &definition)
+	expectedDefinition := Location{URI: testURI, Range: Range{Start: Position{1, 6}, End: Position{1, 9}}}
+	if definition != expectedDefinition {
+		t.Errorf("wrong definition. want=%+v, got=%+v", expectedDefinition, definition)
+	}
+
+	var references []Location
+	response(t, sent, 3, &references)
+	expectedReferences := []Location{
+		{URI: testURI, Range: Range{Start: Position{0, 13}, End: Position{0, 14}}},
+		{URI: testURI, Range: Range{Start: Position{1, 12}, End: Position{1, 13}}},
+	}
+	if !reflect.DeepEqual(references, expectedReferences) {
+		t.Errorf("wrong references. want=%+v, got=%+v", expectedReferences, references)
+	}
+
+	for id, expected := range map[int]string{
+		4: "let x",
+		5: "builtin puts/0+",
+		6: "namespace math",
+	} {
+		var hover Hover
+		response(t, sent, id, &hover)
+		if want := "```monkey\n" + expected + "\n```"; hover.Contents.Value != want {
+			t.Errorf("wrong hover %d. want=%q, got=%q", id, want, hover.Contents.Value)
+		}
+	}
+
+	var symbols []SymbolInformation
+	response(t, sent, 7, &symbols)
+	expectedSymbols := []SymbolInformation{
+		{Name: "add", Kind: symbolKindFunction, Location: Location{URI: testURI, Range: Range{Start: Position{0, 4}, End: Position{0, 7}}}},
+		{Name: "sum", Kind: symbolKindVariable, Location: Location{URI: testURI, Range: Range{Start: Position{1, 6}, End: Position{1, 9}}}, ContainerName: "add"},
+		{Name: "x", Kind: symbolKindVariable, Location: Location{URI: testURI, Range: Range{Start: Position{4, 4}, End: Position{4, 5}}}},
+	}
+	if !reflect.DeepEqual(symbols, expectedSymbols) {
+		t.Errorf("wrong symbols. want=%+v, got=%+v", expectedSymbols, symbols)
+	}
+
+	var completion []CompletionItem
+	response(t, sent, 8, &completion)
+	expectedCompletion := []CompletionItem{
+		{Label: "abs", Kind: completionKindFunction, Detail: "builtin math.abs/1"},
+		{Label: "sqrt", Kind: completionKindFunction, Detail: "builtin math.sqrt/1"},
+	}
+	if !reflect.DeepEqual(completion, expectedCompletion) {
+		t.Errorf("wrong completion. want=%+v, got=%+v", expectedCompletion, completion)
+	}
+
+	var none interface{}
+	response(t, sent, 9, &none)
+	if none != nil {
+		t.Errorf("expected no definition outside the identifiers, got=%v", none)
+	}
+}
+
+func TestDocumentHover(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = 1; a", "let a: INTEGER"},
+		{"let a = -1.5; a", "let a: FLOAT"},
+		{"let a = 1 + 2.5; a", "let a: FLOAT"},
+		{"let a = \"x\" + \"y\"; a", "let a: STRING"},
+		{"let a = [] == {}; a", "let a: BOOLEAN"},
+		{"let a = !1; a", "let a: BOOLEAN"},
+		{"let a = {}; let b = a; b", "let b: HASH"},
+		{"let a = len; a", "let a: BUILTIN"},
+		{"let a = true + 1; a", "let a"},
+		{"let a = b; let b = a; b", "let b"},
+		{"let f = fn(x, ...y) { x }; f", "let f = fn(x, ...y)"},
+		{"let f = fn(x) { x }", "parameter x of f"},
+		{"fn(x) { x }", "parameter x"},
+		{"for (i in []) { i }", "for variable i"},
+		{"try { 1 } catch (e) { e }", "catch variable e"},
+		{"len", "builtin len/1"},
+	}
+	for _, tt := range tests {
+		doc := newDocument(testURI, tt.input, object.NewRegistry())
+		// the last character of the input is the end of an identifier
+		hover, ok := doc.hover(len(strings.TrimRight(tt.input, " }")))
+		if !ok {
+			t.Errorf("no hover for %q", tt.input)
+			continue
+		}
+		if want := "```monkey\n" + tt.expected + "\n```"; hover.Contents.Value != want {
+			t.Errorf("wrong hover for %q. want=%q, got=%q", tt.input, want, hover.Contents.Value)
+		}
+	}
+}
+
+func TestDocumentCompletion(t *testing.T) {
+	doc := newDocument(testURI, "le", object.NewRegistry())
+	items := doc.completion(2)
+	labels := map[string]int{}
+	for _, item := range items {
+		labels[item.Label] = item.Kind
+	}
+	if labels["len"] != completionKindFunction || labels["math"] != completionKindModule || labels["strings"] != completionKindModule {
+		t.Errorf("the builtins and namespaces must be completed, got=%+v", items)
+	}
+}
+
+func TestServerDiagnostics(t *testing.T) {
+	open := request(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI, "languageId": "monkey", "version": 1, "text": "let é = \"😀\" +;\nlet = 1;"},
+	})
+	change := request(0, "textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": testURI, "version": 2},
+		"contentChanges": []map[string]string{{"text": "let x = 1;"}},
+	})
+	close := request(0, "textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]string{"uri": testURI},
+	})
+	sent, err := runServer(t, request(1, "initialize", nil), open, change, close, request(2, "shutdown", nil), request(0, "exit", nil))
+	if err != nil {
+		t.Fatalf("the server failed: %s", err)
+	}
+
+	var published []PublishDiagnosticsParams
+	for _, msg := range sent {
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params PublishDiagnosticsParams
+			if err := json.Unmarshal(msg.Params, &params); err != nil {
+				t.Fatal(err)
+			}
+			published = append(published, params)
+		}
+	}
+	if len(published) != 3 {
+		t.Fatalf("expected diagnostics after opening, changing and closing, got=%+v", published)
+	}
+	// the character offsets are in UTF-16 code units: the emoji takes two
+	expected := []Diagnostic{
+		{Range: Range{Start: Position{0, 14}, End: Position{0, 15}}, Severity: severityError, Source: "monkey",
+			Message: "no prefix parse function for ; found"},
+		{Range: Range{Start: Position{1, 4}, End: Position{1, 5}}, Severity: severityError, Source: "monkey",
+			Message: "expected next token to be IDENT, got = instead"},
+	}
+	if !reflect.DeepEqual(published[0].Diagnostics, expected) {
+		t.Errorf("wrong diagnostics.\nwant=%+v\ngot= %+v", expected, published[0].Diagnostics)
+	}
+	for _, params := range published[1:] {
+		if params.URI != testURI || len(params.Diagnostics) != 0 {
+			t.Errorf("expected no diagnostics, got=%+v", params)
+		}
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	sent, err := runServer(t,
+		request(1, "textDocument/hover", textPosition(0, 0)),
+		request(2, "initialize", nil),
+		request(3, "textDocument/formatting", nil),
+		request(4, "textDocument/hover", textPosition(0, 0)),
+		"Content-Length: 5\r\n\r\n{oops",
+		request(0, "exit", nil),
+	)
+	if err != errExitWithoutShutdown {
+		t.Errorf("expected an error exiting without shutdown, got=%v", err)
+	}
+
+	codes := map[string]int{}
+	for _, msg := range sent {
+		if msg.Error != nil && msg.ID != nil {
+			codes[string(*msg.ID)] = msg.Error.Code
+		} else if msg.Error != nil {
+			codes["null"] = msg.Error.Code
+		}
+	}
+	expected := map[string]int{
+		"1":    codeServerNotInitialized,
+		"3":    codeMethodNotFound,
+		"4":    codeInvalidParams, // the document is not open
+		"null": codeParseError,
+	}
+	if !reflect.DeepEqual(codes, expected) {
+		t.Errorf("wrong errors. want=%v, got=%v", expected, codes)
+	}
+}
+
+// TestDocumentIncomplete checks that the requests don't fail while the code is
+// being written and can't be parsed
+func TestDocumentIncomplete(t *testing.T) {
+	program := testProgram + `for (i in [1, {"a": fn(x = 1, ...r) { try { throw x } catch (e) { e } finally { r } }}]) { if (i) { i } else { !i } }`
+	for end := 0; end <= len(program); end++ {
+		doc := newDocument(testURI, program[:end], object.NewRegistry())
+		doc.diagnostics()
+		doc.symbols()
+		for offset := 0; offset <= end; offset++ {
+			doc.definition(offset)
+			doc.references(offset, true)
+			doc.hover(offset)
+			doc.completion(offset)
+		}
+	}
+}
@@ -1,19 +1,102 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 
+	"github.com/juandspy/monkey-lang/lsp"
+	"github.com/juandspy/monkey-lang/object"
 	"github.com/juandspy/monkey-lang/repl"
 )
 
+const usage = `Usage:
+  monkey [flags]                       start the REPL, or run the program piped to stdin
+  monkey [flags] repl                  start the REPL
+  monkey [flags] run file.mk [args...] run the program in file.mk ("-" reads stdin)
+  monkey [flags] file.mk [args...]     same as run, useful for #! scripts
+  monkey lsp                           start the language server on stdin and stdout
+
+Flags:
+`
+
 func main() {
+	engine := flag.String("engine", repl.EngineEval,
+		fmt.Sprintf("engine used to run the code: %q or %q", repl.EngineEval, repl.EngineVM))
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *engine != repl.EngineEval && *engine != repl.EngineVM {
+		fmt.Fprintf(os.Stderr, "unknown engine %q\n", *engine)
+		os.Exit(2)
+	}
+
+	args := flag.Args()
+	switch {
+	case len(args) == 0 && isTerminal(os.Stdin):
+		startREPL(*engine)
+	case len(args) == 0:
+		os.Exit(runFile("-", nil, *engine))
+	case args[0] == "repl":
+		startREPL(*engine)
+	case args[0] == "lsp":
+		if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case args[0] == "run":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		os.Exit(runFile(args[1], args[2:], *engine))
+	default:
+		os.Exit(runFile(args[0], args[1:], *engine))
+	}
+}
+
+func startREPL(engine string) {
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, engine)
+}
+
+// runFile runs the program in the given file, or in stdin if it's "-", and
+// returns the exit code
+func runFile(filename string, args []string, engine string) int {
+	var input []byte
+	var err error
+	if filename == "-" {
+		filename = "<stdin>"
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	streams := object.NewStreams(os.Stdin, os.Stdout, os.Stderr)
+	if !repl.Run(filename, string(input), args, streams, engine) {
+		return 1
+	}
+	return 0
+}
+
+// isTerminal reports whether the file is a terminal rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
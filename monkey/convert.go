@@ -0,0 +1,420 @@
+package monkey
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/juandspy/monkey-lang/evaluator"
+	"github.com/juandspy/monkey-lang/object"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// ToObject converts a Go value to a Monkey one:
+//
+//   - nil to null, and a pointer or an interface to the value it points to
+//   - integers to INTEGER, floats to FLOAT, strings to STRING, bools to BOOLEAN
+//   - slices and arrays to ARRAY
+//   - maps to HASH, if their keys are integers, floats, strings or bools
+//   - structs to HASH, with the exported fields as keys. The key is the name of
+//     the field or the one in its `monkey:"name"` tag, and "-" skips it.
+//   - functions to builtins, see Interpreter.RegisterFunc
+//
+// An object.Object is returned as it is. Cyclic values, like a map that
+// contains itself, are an error.
+func ToObject(value interface{}) (object.Object, error) {
+	return toObject(reflect.ValueOf(value))
+}
+
+func toObject(v reflect.Value) (object.Object, error) {
+	c := &converter{visiting: map[visit]bool{}}
+	return c.toObject(v)
+}
+
+// converter converts Go values to Monkey ones, keeping track of the values
+// being converted to detect the cycles
+type converter struct {
+	visiting map[visit]bool // the pointers, maps and slices that contain the current value
+}
+
+// visit identifies a pointer, map or slice. Slices also need the length, as
+// the ones that share the same array can have different lengths.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+func (c *converter) toObject(v reflect.Value) (object.Object, error) {
+	if !v.IsValid() {
+		return evaluator.NULL, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+	}
+	if v.Type().Implements(objectType) {
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		key := visit{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			key.len = v.Len()
+		}
+		if c.visiting[key] {
+			return nil, fmt.Errorf("cannot convert a cyclic %s", v.Type())
+		}
+		c.visiting[key] = true
+		defer delete(c.visiting, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return c.toObject(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows INTEGER", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := c.toObject(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		hash := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := c.setPair(hash, iter.Key(), iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+	case reflect.Struct:
+		hash := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+		for i := 0; i < v.NumField(); i++ {
+			name, ok := fieldName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+			if err := c.setPair(hash, reflect.ValueOf(name), v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return funcToBuiltin(v)
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a Monkey value", v.Type())
+	}
+}
+
+func (c *converter) setPair(hash *object.Hash, key, value reflect.Value) error {
+	keyObj, err := c.toObject(key)
+	if err != nil {
+		return err
+	}
+	hashKey, ok := keyObj.(object.Hashable)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", keyObj.Type())
+	}
+	valueObj, err := c.toObject(value)
+	if err != nil {
+		return err
+	}
+	hash.Pairs[hashKey.HashKey()] = object.HashPair{Key: keyObj, Value: valueObj}
+	return nil
+}
+
+// fieldName returns the key of the struct field in a hash, and false if the
+// field is skipped
+func fieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false // unexported
+	}
+	switch tag := field.Tag.Get("monkey"); tag {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	default:
+		return tag, true
+	}
+}
+
+// FromObject stores the Monkey value in the Go value pointed to by target,
+// doing the opposite conversions of ToObject. Integers can be stored in floats
+// too, and null in pointers, interfaces, slices and maps as nil. Storing in an
+// interface{} gives int64, float64, string, bool, nil, []interface{} and
+// map[string]interface{}, or the object.Object itself for the other types.
+func FromObject(obj object.Object, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+	value, err := fromObject(obj, ptr.Type().Elem())
+	if err != nil {
+		return err
+	}
+	ptr.Elem().Set(value)
+	return nil
+}
+
+func fromObject(obj object.Object, typ reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		obj = evaluator.NULL
+	}
+	if typ.Kind() == reflect.Interface && typ.NumMethod() == 0 {
+		native, err := toNative(obj)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if native == nil {
+			return reflect.Zero(typ), nil
+		}
+		return reflect.ValueOf(native), nil
+	}
+	if reflect.TypeOf(obj).AssignableTo(typ) {
+		return reflect.ValueOf(obj), nil
+	}
+	if _, ok := obj.(*object.Null); ok {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			return reflect.Zero(typ), nil
+		}
+	}
+
+	value := reflect.New(typ).Elem()
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		if typ.Kind() == reflect.Bool {
+			value.SetBool(obj.Value)
+			return value, nil
+		}
+	case *object.Integer:
+		switch typ.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if value.OverflowInt(obj.Value) {
+				return value, fmt.Errorf("%d overflows %s", obj.Value, typ)
+			}
+			value.SetInt(obj.Value)
+			return value, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if obj.Value < 0 || value.OverflowUint(uint64(obj.Value)) {
+				return value, fmt.Errorf("%d overflows %s", obj.Value, typ)
+			}
+			value.SetUint(uint64(obj.Value))
+			return value, nil
+		case reflect.Float32, reflect.Float64:
+			value.SetFloat(float64(obj.Value))
+			return value, nil
+		}
+	case *object.Float:
+		switch typ.Kind() {
+		case reflect.Float32, reflect.Float64:
+			value.SetFloat(obj.Value)
+			return value, nil
+		}
+	case *object.String:
+		if typ.Kind() == reflect.String {
+			value.SetString(obj.Value)
+			return value, nil
+		}
+	case *object.Array:
+		if typ.Kind() != reflect.Slice && typ.Kind() != reflect.Array {
+			break
+		}
+		if typ.Kind() == reflect.Slice {
+			value = reflect.MakeSlice(typ, len(obj.Elements), len(obj.Elements))
+		} else if typ.Len() != len(obj.Elements) {
+			return value, fmt.Errorf("cannot convert ARRAY of %d elements to %s", len(obj.Elements), typ)
+		}
+		for i, element := range obj.Elements {
+			elementValue, err := fromObject(element, typ.Elem())
+			if err != nil {
+				return value, err
+			}
+			value.Index(i).Set(elementValue)
+		}
+		return value, nil
+	case *object.Hash:
+		switch typ.Kind() {
+		case reflect.Map:
+			value = reflect.MakeMapWithSize(typ, len(obj.Pairs))
+			for _, pair := range obj.Pairs {
+				key, err := fromObject(pair.Key, typ.Key())
+				if err != nil {
+					return value, err
+				}
+				elem, err := fromObject(pair.Value, typ.Elem())
+				if err != nil {
+					return value, err
+				}
+				value.SetMapIndex(key, elem)
+			}
+			return value, nil
+		case reflect.Struct:
+			for i := 0; i < typ.NumField(); i++ {
+				name, ok := fieldName(typ.Field(i))
+				if !ok {
+					continue
+				}
+				pair, ok := obj.Pairs[(&object.String{Value: name}).HashKey()]
+				if !ok {
+					continue
+				}
+				field, err := fromObject(pair.Value, typ.Field(i).Type)
+				if err != nil {
+					return value, fmt.Errorf("field %s: %w", name, err)
+				}
+				value.Field(i).Set(field)
+			}
+			return value, nil
+		}
+	}
+	if typ.Kind() == reflect.Ptr {
+		elem, err := fromObject(obj, typ.Elem())
+		if err != nil {
+			return value, err
+		}
+		value = reflect.New(typ.Elem())
+		value.Elem().Set(elem)
+		return value, nil
+	}
+	return value, cannotConvert(obj, typ)
+}
+
+// toNative returns the Go value that a Monkey value is converted to when the
+// target is an interface{}
+func toNative(obj object.Object) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			native, err := toNative(element)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = native
+		}
+		return elements, nil
+	case *object.Hash:
+		pairs := make(map[string]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, ok := pair.Key.(*object.String)
+			if !ok {
+				return nil, fmt.Errorf("cannot convert HASH with %s keys to map[string]interface {}", pair.Key.Type())
+			}
+			native, err := toNative(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			pairs[key.Value] = native
+		}
+		return pairs, nil
+	default:
+		return obj, nil
+	}
+}
+
+func cannotConvert(obj object.Object, typ reflect.Type) error {
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), typ)
+}
+
+// funcToBuiltin wraps a Go function in a builtin, see Interpreter.RegisterFunc
+func funcToBuiltin(fn reflect.Value) (*object.Builtin, error) {
+	typ := fn.Type()
+	numOut := typ.NumOut()
+	returnsError := numOut > 0 && typ.Out(numOut-1) == errorType
+	if numOut > 2 || (numOut == 2 && !returnsError) {
+		return nil, fmt.Errorf("cannot convert %s to a builtin: it must return at most a value and an error", typ)
+	}
+	numParams := typ.NumIn()
+	maxArgs := numParams
+	if typ.IsVariadic() {
+		numParams--
+		maxArgs = -1
+	}
+
+	return &object.Builtin{
+		MinArgs: numParams,
+		MaxArgs: maxArgs,
+		// the results are converted to new values
+		Allocates: true,
+		Fn: func(execution *object.Execution, args ...object.Object) object.Object {
+			if err := object.CheckArguments(numParams, 0, typ.IsVariadic(), len(args)); err != nil {
+				return err
+			}
+			in := make([]reflect.Value, len(args))
+			for i, arg := range args {
+				var paramType reflect.Type
+				if i < numParams {
+					paramType = typ.In(i)
+				} else {
+					paramType = typ.In(numParams).Elem()
+				}
+				value, err := fromObject(arg, paramType)
+				if err != nil {
+					return &object.Error{Message: fmt.Sprintf("argument %d: %s", i+1, err)}
+				}
+				in[i] = value
+			}
+
+			out := fn.Call(in)
+			if returnsError {
+				if err := out[len(out)-1]; !err.IsNil() {
+					if objErr, ok := err.Interface().(*object.Error); ok {
+						return objErr
+					}
+					return &object.Error{Message: err.Interface().(error).Error()}
+				}
+				out = out[:len(out)-1]
+			}
+			if len(out) == 0 {
+				return nil
+			}
+			result, err := toObject(out[0])
+			if err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+			return result
+		},
+	}, nil
+}
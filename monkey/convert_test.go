@@ -0,0 +1,148 @@
+package monkey
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/juandspy/monkey-lang/object"
+)
+
+func TestToObject(t *testing.T) {
+	type point struct {
+		X, Y    int
+		Label   string `monkey:"label"`
+		Skipped bool   `monkey:"-"`
+		private int
+	}
+	n := 3
+
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{int8(-5), "-5"},
+		{uint16(5), "5"},
+		{1.5, "1.5"},
+		{"hi", "hi"},
+		{&n, "3"},
+		{(*int)(nil), "null"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{map[int]string{1: "a"}, "{1: a}"},
+		{&object.Integer{Value: 7}, "7"},
+	}
+	for _, tt := range tests {
+		obj, err := ToObject(tt.value)
+		if err != nil {
+			t.Errorf("%#v: unexpected error: %s", tt.value, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("%#v: wrong object. expected=%s, got=%s", tt.value, tt.expected, obj.Inspect())
+		}
+	}
+
+	obj, _ := ToObject(point{X: 1, Y: 2, Label: "p", Skipped: true})
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		t.Fatalf("a struct must be a hash, got=%T", obj)
+	}
+	var fields map[string]interface{}
+	if err := FromObject(hash, &fields); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{"X": int64(1), "Y": int64(2), "label": "p"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("wrong fields. expected=%v, got=%v", expected, fields)
+	}
+
+	for _, value := range []interface{}{uint64(1 << 63), map[interface{}]int{nil: 1}, make(chan int)} {
+		if _, err := ToObject(value); err == nil {
+			t.Errorf("%#v: expected an error", value)
+		}
+	}
+
+	type node struct {
+		Next *node
+	}
+	cyclicNode := &node{}
+	cyclicNode.Next = cyclicNode
+	cyclicMap := map[string]interface{}{}
+	cyclicMap["self"] = cyclicMap
+	cyclicSlice := []interface{}{nil}
+	cyclicSlice[0] = cyclicSlice
+	for _, value := range []interface{}{cyclicNode, cyclicMap, cyclicSlice} {
+		if _, err := ToObject(value); err == nil || !strings.Contains(err.Error(), "cyclic") {
+			t.Errorf("%T: expected an error for the cycle, got=%v", value, err)
+		}
+	}
+
+	// a value referenced twice is not a cycle
+	shared := []int{1}
+	obj, err := ToObject([][]int{shared, shared})
+	if err != nil || obj.Inspect() != "[[1], [1]]" {
+		t.Errorf("wrong conversion of a shared value. got=%v, %v", obj, err)
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	type config struct {
+		Name  string `monkey:"name"`
+		Ports []uint16
+		Debug *bool
+	}
+	interp := New()
+	obj, err := interp.Eval(`{"name": "api", "Ports": [80, 443], "Debug": true, "other": 1}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var cfg config
+	if err := FromObject(obj, &cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Name != "api" || !reflect.DeepEqual(cfg.Ports, []uint16{80, 443}) || cfg.Debug == nil || !*cfg.Debug {
+		t.Errorf("wrong config, got=%+v", cfg)
+	}
+
+	obj, _ = interp.Eval(`[1, 2.5, "a", first([]), [true], {"k": 1}]`)
+	var native interface{}
+	if err := FromObject(obj, &native); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []interface{}{int64(1), 2.5, "a", nil, []interface{}{true}, map[string]interface{}{"k": int64(1)}}
+	if !reflect.DeepEqual(native, expected) {
+		t.Errorf("wrong native value. expected=%#v, got=%#v", expected, native)
+	}
+
+	var f float64
+	if err := FromObject(&object.Integer{Value: 2}, &f); err != nil || f != 2 {
+		t.Errorf("an integer must be stored in a float, got=%v (%v)", f, err)
+	}
+
+	errorTests := []struct {
+		input    string
+		target   interface{}
+		expected string
+	}{
+		{"300", new(uint8), "300 overflows uint8"},
+		{"-1", new(uint), "-1 overflows uint"},
+		{`"a"`, new(int), "cannot convert STRING to int"},
+		{"first([])", new(string), "cannot convert NULL to string"},
+		{"[1, 2]", new([3]int), "cannot convert ARRAY of 2 elements to [3]int"},
+		{"{1: 2}", new(interface{}), "cannot convert HASH with INTEGER keys to map[string]interface {}"},
+		{`{"name": 1}`, new(config), "field name: cannot convert INTEGER to string"},
+	}
+	for _, tt := range errorTests {
+		obj, _ := interp.Eval(tt.input)
+		err := FromObject(obj, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. expected=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+	if err := FromObject(obj, cfg); err == nil {
+		t.Errorf("expected an error with a target that is not a pointer")
+	}
+}
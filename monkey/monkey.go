@@ -0,0 +1,125 @@
+// Package monkey embeds the Monkey interpreter in Go programs. An Interpreter
+// runs scripts with the tree-walking evaluator and converts the values passed
+// between Go and Monkey, see ToObject and FromObject.
+package monkey
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/juandspy/monkey-lang/evaluator"
+	"github.com/juandspy/monkey-lang/lexer"
+	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/parser"
+)
+
+// Interpreter runs Monkey code, keeping the bindings between calls to Eval
+type Interpreter struct {
+	env *object.Environment
+}
+
+// New returns an Interpreter with the standard builtins defined
+func New() *Interpreter {
+	return &Interpreter{env: object.NewEnvironment()}
+}
+
+// Builtins returns the registry of the builtins of the interpreter, to add
+// functions or remove the ones the scripts must not use, like `puts`
+func (i *Interpreter) Builtins() *object.Registry {
+	return i.env.Builtins()
+}
+
+// SetStreams changes the standard input and outputs of the scripts, which are
+// the ones of the process by default
+func (i *Interpreter) SetStreams(streams *object.Streams) {
+	i.env.Execution().SetStreams(streams)
+}
+
+// ParseError is returned by Eval when the code has syntax errors
+type ParseError struct {
+	Errors []*parser.Error
+}
+
+func (e *ParseError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Eval runs the code and returns the value of its last statement. Syntax
+// errors are returned as a *ParseError and runtime errors as an *object.Error.
+func (i *Interpreter) Eval(src string) (object.Object, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors()}
+	}
+	return result(evaluator.Eval(program, i.env))
+}
+
+// Set binds the name to the Go value, converted with ToObject, like a `let`
+func (i *Interpreter) Set(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return err
+	}
+	i.env.Set(name, obj)
+	return nil
+}
+
+// Get returns the value bound to the name. It can be converted to a Go value
+// with FromObject.
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	return i.env.Get(name)
+}
+
+// Call calls the function bound to fnName with the given arguments, converted
+// with ToObject
+func (i *Interpreter) Call(fnName string, args ...interface{}) (object.Object, error) {
+	fn, ok := i.env.Get(fnName)
+	if !ok {
+		return nil, &object.Error{Message: "identifier not found: " + fnName}
+	}
+	objects := make([]object.Object, len(args))
+	for idx, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", idx+1, err)
+		}
+		objects[idx] = obj
+	}
+	return result(evaluator.Call(fn, objects, i.env))
+}
+
+// RegisterFunc registers a builtin that calls the Go function fn. The name can
+// have a namespace, like "strings.reverse". The arguments are converted to the
+// types of its parameters with FromObject, and its result with ToObject. It
+// can return nothing, a value, an error, or a value and an error, which
+// becomes a Monkey error.
+func (i *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return fmt.Errorf("cannot register %T as a function", fn)
+	}
+	builtin, err := funcToBuiltin(value)
+	if err != nil {
+		return err
+	}
+	i.env.Builtins().Register(name, builtin)
+	return nil
+}
+
+// result turns the value returned by the evaluator into the one returned to
+// the host
+func result(obj object.Object) (object.Object, error) {
+	if err, ok := obj.(*object.Error); ok {
+		return nil, err
+	}
+	if obj == nil {
+		return evaluator.NULL, nil
+	}
+	return obj, nil
+}
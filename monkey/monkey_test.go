@@ -0,0 +1,199 @@
+package monkey
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/juandspy/monkey-lang/object"
+)
+
+func TestEval(t *testing.T) {
+	interp := New()
+	result, err := interp.Eval("let add = fn(a, b) { a + b };")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Type() != object.NULL_OBJ {
+		t.Errorf("a let must give null, got=%s", result.Inspect())
+	}
+	// the bindings are kept between calls
+	result, err = interp.Eval("add(1, 2)")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if result.Inspect() != "3" {
+		t.Errorf("wrong result. expected=3, got=%s", result.Inspect())
+	}
+
+	_, err = interp.Eval("let x 1;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || len(parseErr.Errors) != 1 {
+		t.Errorf("expected a ParseError, got=%v", err)
+	}
+
+	_, err = interp.Eval("1 + true")
+	var runtimeErr *object.Error
+	if !errors.As(err, &runtimeErr) || runtimeErr.Message != "type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("expected a runtime error, got=%v", err)
+	}
+}
+
+func TestSetStreams(t *testing.T) {
+	var out, errOut bytes.Buffer
+	interp := New()
+	interp.SetStreams(object.NewStreams(strings.NewReader("ana\n"), &out, &errOut))
+	if _, err := interp.Eval(`let f = fn() { puts("hi " + input("name: ")) }; f(); eprint("done")`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "name: hi ana\n" || errOut.String() != "done" {
+		t.Errorf("wrong output. got=%q and %q", out.String(), errOut.String())
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	type user struct {
+		Name  string `monkey:"name"`
+		Admin bool   `monkey:"admin"`
+		Tags  []string
+	}
+
+	interp := New()
+	if err := interp.Set("user", user{Name: "ana", Admin: true, Tags: []string{"a", "b"}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := interp.Set("limit", 10); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, err := interp.Eval(`let greeting = if (user["admin"] && len(user["Tags"]) < limit) { "hi " + user["name"] } else { "no" }`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	greeting, ok := interp.Get("greeting")
+	if !ok {
+		t.Fatalf("greeting is not defined")
+	}
+	var s string
+	if err := FromObject(greeting, &s); err != nil || s != "hi ana" {
+		t.Errorf("wrong greeting. expected=%q, got=%q (%v)", "hi ana", s, err)
+	}
+	if _, ok := interp.Get("missing"); ok {
+		t.Errorf("missing must not be defined")
+	}
+	if err := interp.Set("ch", make(chan int)); err == nil {
+		t.Errorf("expected an error setting a channel")
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := New()
+	if _, err := interp.Eval(`let total = fn(prices, tax = 0.0) { let s = 0.0; for (p in prices) { s += p }; s * (1.0 + tax) }`); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result, err := interp.Call("total", []float64{10, 20}, 0.5)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var total float64
+	if err := FromObject(result, &total); err != nil || total != 45 {
+		t.Errorf("wrong total. expected=45, got=%v (%v)", total, err)
+	}
+
+	if _, err := interp.Call("missing"); err == nil || err.Error() != "identifier not found: missing" {
+		t.Errorf("wrong error calling a missing function, got=%v", err)
+	}
+	if _, err := interp.Call("total"); err == nil || !strings.Contains(err.Error(), "wrong number of arguments") {
+		t.Errorf("wrong error calling with no arguments, got=%v", err)
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	interp := New()
+	funcs := map[string]interface{}{
+		"upper":   strings.ToUpper,
+		"sum":     sum,
+		"split":   func(s, sep string) []string { return strings.Split(s, sep) },
+		"noop":    func() {},
+		"check":   check,
+		"ages":    func(people map[string]int) int { return people["ana"] + people["bob"] },
+		"explode": func() { panic("boom") },
+	}
+	for name, fn := range funcs {
+		if err := interp.RegisterFunc(name, fn); err != nil {
+			t.Fatalf("unexpected error registering %s: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`upper("monkey")`, "MONKEY"},
+		{`upper("abc") + "!"`, "ABC!"},
+		{"sum()", "0"},
+		{"sum(1, 2, 3)", "6"},
+		{`split("a,b", ",")`, "[a, b]"},
+		{"noop()", "null"},
+		{"check(11)", "true"},
+		{`ages({"ana": 30, "bob": 40})`, "70"},
+	}
+	for _, tt := range tests {
+		result, err := interp.Eval(tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. expected=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{"check(-1)", "negative"},
+		{"check()", "wrong number of arguments: want=1, got=0"},
+		{`check("a")`, "argument 1: cannot convert STRING to int"},
+		{"explode()", "internal error: boom"},
+		{`try { check(-1) } catch (e) { throw e["message"] + "!" }`, "negative!"},
+	}
+	for _, tt := range errorTests {
+		_, err := interp.Eval(tt.input)
+		var runtimeErr *object.Error
+		if !errors.As(err, &runtimeErr) || runtimeErr.Message != tt.expected {
+			t.Errorf("%q: wrong error. expected=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	if err := interp.RegisterFunc("strings.repeat", strings.Repeat); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result, err := interp.Eval(`strings.repeat("ab", 2) + strings.join(["c", "d"], "")`)
+	if err != nil || result.Inspect() != "ababcd" {
+		t.Errorf("wrong result of a namespaced function, got=%v (%v)", result, err)
+	}
+
+	if err := interp.RegisterFunc("x", 1); err == nil {
+		t.Errorf("expected an error registering an integer")
+	}
+	if err := interp.RegisterFunc("x", func() (int, int) { return 1, 2 }); err == nil {
+		t.Errorf("expected an error registering a function with two results")
+	}
+}
+
+func sum(xs ...int) int {
+	s := 0
+	for _, x := range xs {
+		s += x
+	}
+	return s
+}
+
+func check(n int) (bool, error) {
+	if n < 0 {
+		return false, errors.New("negative")
+	}
+	return n > 10, nil
+}
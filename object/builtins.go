@@ -0,0 +1,505 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Builtins is the list of the standard builtin functions, shared by the
+// evaluator and the VM. NewRegistry defines them in this order, which gives
+// their index for the compiler. The names with a dot, like "math.sqrt", are
+// functions of a namespace. Builtins returning nil mean they return the
+// engine's NULL.
+var Builtins = []*Builtin{
+	{
+		Name:    "len",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(len(arg.Value))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Range:
+				return &Integer{Value: arg.Len()}
+			default:
+				return newError("argument to `len` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	{
+		Name:    "puts",
+		MinArgs: 0,
+		MaxArgs: -1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			for _, arg := range args {
+				fmt.Fprintln(execution.Streams().Stdout, arg.Inspect())
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "first",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[0]
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "last",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[length-1]
+			}
+			return nil
+		},
+	},
+	// returns all the elements except the first one
+	{
+		Name:    "rest",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if length > 0 {
+				newElements := make([]Object, length-1, length-1)
+				copy(newElements, arr.Elements[1:length])
+				return &Array{Elements: newElements}
+			}
+			return nil
+		},
+		Allocates: true,
+	},
+	{
+		Name:    "push",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			newElements := make([]Object, length+1, length+1)
+			copy(newElements, arr.Elements)
+			newElements[length] = args[1]
+			return &Array{Elements: newElements}
+		},
+		Allocates: true,
+	},
+	// returns the characters (Unicode code points) of a string, as `len` counts bytes
+	{
+		Name:    "chars",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != STRING_OBJ {
+				return newError("argument to `chars` must be STRING, got %s", args[0].Type())
+			}
+			str := args[0].(*String)
+			elements := []Object{}
+			for _, r := range str.Value {
+				elements = append(elements, &String{Value: string(r)})
+			}
+			return &Array{Elements: elements}
+		},
+		Allocates: true,
+	},
+	// converts a float (truncating it), string or boolean to integer
+	{
+		Name:    "int",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer:
+				return arg
+			case *Float:
+				return floatToInteger(arg.Value)
+			case *String:
+				value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 0, 64)
+				if err != nil {
+					return newError("could not convert %q to INTEGER", arg.Value)
+				}
+				return &Integer{Value: value}
+			case *Boolean:
+				if arg.Value {
+					return &Integer{Value: 1}
+				}
+				return &Integer{Value: 0}
+			default:
+				return newError("argument to `int` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	// converts an integer or string to float
+	{
+		Name:    "float",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer:
+				return &Float{Value: float64(arg.Value)}
+			case *Float:
+				return arg
+			case *String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("could not convert %q to FLOAT", arg.Value)
+				}
+				return &Float{Value: value}
+			default:
+				return newError("argument to `float` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+	// rounds to the nearest integer, or to a float with the given number of
+	// decimals. Integers are already rounded and returned unchanged.
+	{
+		Name:    "round",
+		MinArgs: 1,
+		MaxArgs: 2,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2",
+					len(args))
+			}
+			if len(args) == 2 {
+				decimals, ok := args[1].(*Integer)
+				if !ok {
+					return newError("second argument to `round` must be INTEGER, got %s",
+						args[1].Type())
+				}
+				switch arg := args[0].(type) {
+				case *Integer:
+					return arg
+				case *Float:
+					pow := math.Pow(10, float64(decimals.Value))
+					return &Float{Value: math.Round(arg.Value*pow) / pow}
+				}
+			}
+			return applyRounding("round", args[0], math.Round)
+		},
+	},
+	// rounds down to the nearest integer
+	{
+		Name:    "floor",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			return applyRounding("floor", args[0], math.Floor)
+		},
+	},
+	// rounds up to the nearest integer
+	{
+		Name:    "ceil",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			return applyRounding("ceil", args[0], math.Ceil)
+		},
+	},
+	// range(stop), range(start, stop) or range(start, stop, step) returns the
+	// integers from start (0 by default) up to stop, not included
+	{
+		Name:    "range",
+		MinArgs: 1,
+		MaxArgs: 3,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1 to 3",
+					len(args))
+			}
+			values := []int64{}
+			for _, arg := range args {
+				integer, ok := arg.(*Integer)
+				if !ok {
+					return newError("argument to `range` must be INTEGER, got %s", arg.Type())
+				}
+				values = append(values, integer.Value)
+			}
+			r := &Range{Start: 0, Step: 1}
+			switch len(values) {
+			case 1:
+				r.Stop = values[0]
+			case 2:
+				r.Start, r.Stop = values[0], values[1]
+			case 3:
+				r.Start, r.Stop, r.Step = values[0], values[1], values[2]
+			}
+			if r.Step == 0 {
+				return newError("range step cannot be zero")
+			}
+			return r
+		},
+	},
+	// writes the arguments to stdout, one after another without a line break
+	{
+		Name:    "print",
+		MinArgs: 0,
+		MaxArgs: -1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			return write(execution.Streams().Stdout, args)
+		},
+	},
+	// writes the arguments to stderr, like print
+	{
+		Name:    "eprint",
+		MinArgs: 0,
+		MaxArgs: -1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			return write(execution.Streams().Stderr, args)
+		},
+	},
+	// input() or input(prompt) writes the prompt to stdout and reads a line
+	// from stdin, without the line break. It returns null at the end of stdin.
+	{
+		Name:    "input",
+		MinArgs: 0,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1",
+					len(args))
+			}
+			if len(args) == 1 {
+				if err := write(execution.Streams().Stdout, args); err != nil {
+					return err
+				}
+			}
+			return readLine(execution.Streams())
+		},
+		Allocates: true,
+	},
+	// reads a line from stdin like input, without a prompt
+	{
+		Name:    "readline",
+		MinArgs: 0,
+		MaxArgs: 0,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0",
+					len(args))
+			}
+			return readLine(execution.Streams())
+		},
+		Allocates: true,
+	},
+	// square root, always a float
+	{
+		Name:    "math.sqrt",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer:
+				return &Float{Value: math.Sqrt(float64(arg.Value))}
+			case *Float:
+				return &Float{Value: math.Sqrt(arg.Value)}
+			default:
+				return newError("argument to `math.sqrt` must be INTEGER or FLOAT, got %s", arg.Type())
+			}
+		},
+	},
+	// absolute value, keeping the type of the number
+	{
+		Name:    "math.abs",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer:
+				if arg.Value < 0 {
+					return &Integer{Value: -arg.Value}
+				}
+				return arg
+			case *Float:
+				return &Float{Value: math.Abs(arg.Value)}
+			default:
+				return newError("argument to `math.abs` must be INTEGER or FLOAT, got %s", arg.Type())
+			}
+		},
+	},
+	// splits a string around each instance of the separator
+	{
+		Name:    "strings.split",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			str, ok := args[0].(*String)
+			sep, sepOk := args[1].(*String)
+			if !ok || !sepOk {
+				return newError("arguments to `strings.split` must be STRING, got %s and %s",
+					args[0].Type(), args[1].Type())
+			}
+			elements := []Object{}
+			for _, part := range strings.Split(str.Value, sep.Value) {
+				elements = append(elements, &String{Value: part})
+			}
+			return &Array{Elements: elements}
+		},
+		Allocates: true,
+	},
+	// joins an array of strings with the separator
+	{
+		Name:    "strings.join",
+		MinArgs: 2,
+		MaxArgs: 2,
+		Fn: func(execution *Execution, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			arr, ok := args[0].(*Array)
+			sep, sepOk := args[1].(*String)
+			if !ok || !sepOk {
+				return newError("arguments to `strings.join` must be ARRAY and STRING, got %s and %s",
+					args[0].Type(), args[1].Type())
+			}
+			parts := make([]string, len(arr.Elements))
+			for i, element := range arr.Elements {
+				str, ok := element.(*String)
+				if !ok {
+					return newError("elements joined by `strings.join` must be STRING, got %s", element.Type())
+				}
+				parts[i] = str.Value
+			}
+			return &String{Value: strings.Join(parts, sep.Value)}
+		},
+		Allocates: true,
+	},
+}
+
+// write writes the values to the stream, one after another. It returns nil or
+// the error writing them.
+func write(w io.Writer, values []Object) Object {
+	for _, value := range values {
+		if _, err := io.WriteString(w, value.Inspect()); err != nil {
+			return newError("cannot write: %s", err)
+		}
+	}
+	return nil
+}
+
+// readLine returns the next line of stdin as a string, or nil at the end
+func readLine(streams *Streams) Object {
+	line, err := streams.ReadLine()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return newError("cannot read: %s", err)
+	}
+	return &String{Value: line}
+}
+
+// applyRounding rounds a float to an integer with the given function. Integers
+// are returned as they are.
+func applyRounding(name string, arg Object, round func(float64) float64) Object {
+	switch arg := arg.(type) {
+	case *Integer:
+		return arg
+	case *Float:
+		return floatToInteger(round(arg.Value))
+	default:
+		return newError("argument to `%s` must be INTEGER or FLOAT, got %s", name, arg.Type())
+	}
+}
+
+// floatToInteger truncates the float, failing if it doesn't fit in an integer
+func floatToInteger(value float64) Object {
+	if math.IsNaN(value) || value >= math.MaxInt64 || value < math.MinInt64 {
+		return newError("cannot convert %s to INTEGER", (&Float{Value: value}).Inspect())
+	}
+	return &Integer{Value: int64(value)}
+}
+
+// GetBuiltinByName returns the standard builtin with the given name or nil if
+// there is none
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def
+		}
+	}
+	return nil
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -1,20 +1,50 @@
 package object
 
+import "sort"
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	s := make(map[string]Object)
+	return &Environment{store: s, outer: outer, execution: outer.execution, builtins: outer.builtins}
 }
 
+// NewEnvironment returns an environment with the standard builtins, see
+// SetBuiltins to choose them
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{store: s, outer: nil, execution: &Execution{}, builtins: NewRegistry()}
 }
 
 // Environment is used to store the variables and bindings
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+
+	execution *Execution // the program being run in the environment
+	builtins  *Registry  // the builtins available to the program
+}
+
+// Execution returns the program being run in the environment, which enforces
+// the limits of the evaluation
+func (e *Environment) Execution() *Execution {
+	return e.execution
+}
+
+// SetExecution changes the program being run in the environment. Functions
+// run in the execution of their caller, not the one they were defined in.
+func (e *Environment) SetExecution(execution *Execution) {
+	e.execution = execution
+}
+
+// Builtins returns the builtins available in the environment, which are looked
+// up after its bindings
+func (e *Environment) Builtins() *Registry {
+	return e.builtins
+}
+
+// SetBuiltins changes the builtins available in the environment. The enclosed
+// environments created before keep the previous ones.
+func (e *Environment) SetBuiltins(builtins *Registry) {
+	e.builtins = builtins
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -28,3 +58,27 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign updates the binding of the given name in the environment where it was
+// declared, walking the outer environments. It returns false if it's not declared.
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
+
+// Names returns the sorted names bound in the environment, without the ones of
+// the outer environments and the builtins
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,28 @@
+package object
+
+// SetIndex implements the index assignments like `arr[0] = 1` or `hash["a"] = 1`,
+// updating the array or hash in place. Unlike reading, assigning an array
+// element out of range is an error.
+func SetIndex(left, index, value Object) *Error {
+	switch left := left.(type) {
+	case *Array:
+		i, ok := index.(*Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d", i.Value)
+		}
+		left.Elements[i.Value] = value
+		return nil
+	case *Hash:
+		key, ok := index.(Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = HashPair{Key: index, Value: value}
+		return nil
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
@@ -0,0 +1,97 @@
+package object
+
+import (
+	"sort"
+)
+
+// Iterator yields the elements of an iterable object one by one. It's what the
+// `for` loops use under the hood, so the user never gets one.
+type Iterator struct {
+	next   func() (Object, bool)
+	source Object // the object iterated, which is in use until the loop ends
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "iterator" }
+
+// Next returns the next element, or false once all of them have been returned
+func (it *Iterator) Next() (Object, bool) {
+	return it.next()
+}
+
+// NewIterator returns an iterator over the elements of an array, the keys of a
+// hash (sorted, so that the order is always the same), the characters of a
+// string or the integers of a range. It returns false for any other object.
+func NewIterator(obj Object) (*Iterator, bool) {
+	var get func(i int) (Object, bool)
+	switch obj := obj.(type) {
+	case *Array:
+		get = func(i int) (Object, bool) {
+			if i >= len(obj.Elements) {
+				return nil, false
+			}
+			return obj.Elements[i], true
+		}
+	case *Hash:
+		keys := make([]Object, 0, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			keys = append(keys, pair.Key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessHashKey(keys[i], keys[j]) })
+		get = func(i int) (Object, bool) {
+			if i >= len(keys) {
+				return nil, false
+			}
+			return keys[i], true
+		}
+	case *String:
+		chars := []rune(obj.Value)
+		get = func(i int) (Object, bool) {
+			if i >= len(chars) {
+				return nil, false
+			}
+			return &String{Value: string(chars[i])}, true
+		}
+	case *Range:
+		n := obj.Len()
+		get = func(i int) (Object, bool) {
+			if int64(i) >= n {
+				return nil, false
+			}
+			return &Integer{Value: obj.Start + int64(i)*obj.Step}, true
+		}
+	default:
+		return nil, false
+	}
+	return newSliceIterator(obj, get), true
+}
+
+// newSliceIterator returns an iterator over source calling get with 0, 1, 2...
+// until it returns false
+func newSliceIterator(source Object, get func(i int) (Object, bool)) *Iterator {
+	i := 0
+	return &Iterator{source: source, next: func() (Object, bool) {
+		obj, ok := get(i)
+		if ok {
+			i++
+		}
+		return obj, ok
+	}}
+}
+
+// lessHashKey sorts the hash keys by type and then by value
+func lessHashKey(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value < b.(*Integer).Value
+	case *Float:
+		return a.Value < b.(*Float).Value
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	default:
+		return a.Inspect() < b.Inspect()
+	}
+}
@@ -0,0 +1,192 @@
+package object
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Limits bounds the execution of a program, so that the scripts embedded in a
+// service can't run forever. Zero values mean no limit.
+type Limits struct {
+	MaxSteps  int64         // nodes evaluated, or instructions run by the VM
+	MaxDepth  int           // nested function calls
+	MaxMemory int64         // approximate bytes in use by strings, arrays and hashes, see Usage
+	Timeout   time.Duration // wall-clock time
+}
+
+// Usage reports the resources used by an execution, to monitor and bill the
+// scripts. Memory is the peak of the bytes in use. They're counted again from
+// the values the program can still reach once the bytes allocated since the
+// last count exceed the ones in use then, or 4 KiB, so Memory is at most about
+// twice the actual peak plus 4 KiB. Allocated is the total of bytes allocated,
+// including the ones of the values discarded.
+type Usage struct {
+	Steps     int64 // nodes evaluated, or instructions run by the VM
+	Depth     int   // deepest nesting of function calls
+	Memory    int64 // peak of the approximate bytes in use by strings, arrays and hashes
+	Allocated int64 // approximate bytes allocated by strings, arrays and hashes
+}
+
+// MaxCallDepth is the maximum number of nested function calls in both engines,
+// past which a call is a stack overflow. It keeps a deep recursion from
+// overflowing the Go stack in the evaluator, and sizes the frames of the VM.
+const MaxCallDepth = 10000
+
+// LimitError is the Kind of the errors raised when a limit is exceeded or the
+// context of the execution is done. Scripts can't catch them.
+const LimitError = "LimitError"
+
+// contextCheckInterval is the number of steps between checks of the context,
+// which are slower than counting the steps
+const contextCheckInterval = 1024
+
+// minCountInterval is the least number of bytes allocated between two counts of
+// the bytes in use, so that small programs are rarely counted
+const minCountInterval = 4096
+
+// Execution keeps track of a running program to enforce its limits, and gives
+// the builtins the streams of the program. The zero value has no limits and
+// uses the streams of the process.
+type Execution struct {
+	limits  Limits
+	ctx     context.Context
+	steps   int64
+	usage   Usage
+	streams *Streams
+
+	roots   Roots // the values in use, to count their bytes
+	inUse   int64 // bytes in use at the last count plus the ones allocated since
+	counted int64 // bytes in use at the last count
+
+	// Depth is the number of nested function calls, kept by the evaluator
+	Depth int
+	// Frames are the environments of the function calls being evaluated, kept
+	// by the evaluator, whose values are in use
+	Frames []*Environment
+	// Temporaries are the values computed by the statements being evaluated,
+	// kept by the evaluator, which are in use until the statements end
+	Temporaries []Object
+}
+
+// NewExecution returns an Execution that stops when ctx is done or the limits
+// are exceeded. The cancel function releases the timer of the timeout.
+func NewExecution(ctx context.Context, limits Limits) (*Execution, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+	}
+	return &Execution{limits: limits, ctx: ctx}, cancel
+}
+
+// Step counts a step of the execution. It returns an error if there are too
+// many or the context is done.
+func (e *Execution) Step() *Error {
+	e.steps++
+	if e.limits.MaxSteps > 0 && e.steps > e.limits.MaxSteps {
+		return newLimitError("step limit exceeded: %d", e.limits.MaxSteps)
+	}
+	if e.ctx != nil && e.steps%contextCheckInterval == 0 {
+		if err := e.ctx.Err(); err != nil {
+			return newLimitError("execution stopped: %v", err)
+		}
+	}
+	return nil
+}
+
+// Allocate counts the bytes of a new value. It returns an error if the bytes in
+// use exceed the limit. Without roots, see SetRoots, the discarded values are
+// never given back, so the limit bounds the total allocated.
+func (e *Execution) Allocate(obj Object) *Error {
+	size := SizeOf(obj)
+	e.usage.Allocated += size
+	e.inUse += size
+	overLimit := e.limits.MaxMemory > 0 && e.inUse > e.limits.MaxMemory
+	if e.roots != nil && (overLimit || e.inUse-e.counted > max64(e.counted, minCountInterval)) {
+		e.counted = e.countInUse(obj)
+		e.inUse = e.counted
+	}
+	if e.inUse > e.usage.Memory {
+		e.usage.Memory = e.inUse
+	}
+	if e.limits.MaxMemory > 0 && e.inUse > e.limits.MaxMemory {
+		return newLimitError("memory limit exceeded: %d bytes", e.limits.MaxMemory)
+	}
+	return nil
+}
+
+// SetRoots sets the function that marks the values the program can still
+// reach, which are the ones in use along with the Frames and the Temporaries.
+// The engines set it to give back the bytes of the discarded values.
+func (e *Execution) SetRoots(roots Roots) {
+	e.roots = roots
+}
+
+// CheckDepth returns an error if the given number of nested function calls
+// exceeds the limit
+func (e *Execution) CheckDepth(depth int) *Error {
+	if depth > e.usage.Depth {
+		e.usage.Depth = depth
+	}
+	if e.limits.MaxDepth > 0 && depth > e.limits.MaxDepth {
+		return newLimitError("maximum call depth exceeded: %d", e.limits.MaxDepth)
+	}
+	return nil
+}
+
+// Streams returns the standard input and outputs of the program. A nil
+// Execution has the ones of the process.
+func (e *Execution) Streams() *Streams {
+	if e == nil || e.streams == nil {
+		return defaultStreams
+	}
+	return e.streams
+}
+
+// SetStreams changes the standard input and outputs of the program
+func (e *Execution) SetStreams(streams *Streams) {
+	e.streams = streams
+}
+
+// Usage returns the resources used so far
+func (e *Execution) Usage() Usage {
+	usage := e.usage
+	usage.Steps = e.steps
+	return usage
+}
+
+// Approximate sizes of the values on a 64-bit platform
+const (
+	stringSize    = 16 // header with the pointer to the bytes and the length
+	arraySize     = 24 // header of the slice
+	elementSize   = 16 // interface holding an element
+	hashSize      = 48 // header of the map
+	hashEntrySize = 56 // HashKey and HashPair of an entry
+)
+
+// SizeOf returns the approximate number of bytes allocated to create obj,
+// without the values it contains, which are counted when they are created.
+// Other values than strings, arrays and hashes are small and count as 0.
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return stringSize + int64(len(obj.Value))
+	case *Array:
+		return arraySize + elementSize*int64(len(obj.Elements))
+	case *Hash:
+		return hashSize + hashEntrySize*int64(len(obj.Pairs))
+	default:
+		return 0
+	}
+}
+
+func newLimitError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Kind: LimitError}
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
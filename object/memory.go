@@ -0,0 +1,84 @@
+package object
+
+// Roots marks the values a program can still reach, like its globals and its
+// stack, so that the Execution can count the bytes in use
+type Roots func(m *Marker)
+
+// Marker finds the values in use from the ones marked, and adds up their
+// bytes. Each value is counted once, however many times it's reached.
+type Marker struct {
+	seen    map[Object]bool
+	envs    map[*Environment]bool
+	pending []Object
+	size    int64
+}
+
+// Mark marks obj and the values it refers to as in use
+func (m *Marker) Mark(obj Object) {
+	if obj == nil || m.seen[obj] {
+		return
+	}
+	m.seen[obj] = true
+	m.pending = append(m.pending, obj)
+}
+
+// MarkEnvironment marks the bindings of env and of the environments enclosing
+// it as in use
+func (m *Marker) MarkEnvironment(env *Environment) {
+	for ; env != nil && !m.envs[env]; env = env.outer {
+		m.envs[env] = true
+		for _, obj := range env.store {
+			m.Mark(obj)
+		}
+	}
+}
+
+// walk counts the marked values, marking the ones they refer to. It doesn't
+// recurse so that deeply nested arrays can't overflow the Go stack.
+func (m *Marker) walk() {
+	for len(m.pending) > 0 {
+		obj := m.pending[len(m.pending)-1]
+		m.pending = m.pending[:len(m.pending)-1]
+		m.size += SizeOf(obj)
+
+		switch obj := obj.(type) {
+		case *Array:
+			for _, el := range obj.Elements {
+				m.Mark(el)
+			}
+		case *Hash:
+			for _, pair := range obj.Pairs {
+				m.Mark(pair.Key)
+				m.Mark(pair.Value)
+			}
+		case *Function:
+			m.MarkEnvironment(obj.Env)
+		case *Closure:
+			for _, free := range obj.Free {
+				m.Mark(free)
+			}
+		case *Cell:
+			m.Mark(obj.Value)
+		case *ReturnValue:
+			m.Mark(obj.Value)
+		case *Iterator:
+			m.Mark(obj.source)
+		}
+	}
+}
+
+// countInUse returns the bytes of the values reachable from the roots, the
+// Frames, the Temporaries and obj, the value being allocated
+func (e *Execution) countInUse(obj Object) int64 {
+	m := &Marker{seen: map[Object]bool{}, envs: map[*Environment]bool{}}
+	e.roots(m)
+	for _, env := range e.Frames {
+		m.MarkEnvironment(env)
+	}
+	for _, temporary := range e.Temporaries {
+		m.Mark(temporary)
+	}
+	m.Mark(obj)
+	m.walk()
+	return m.size
+}
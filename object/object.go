@@ -4,24 +4,35 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/juandspy/monkey-lang/ast"
+	"github.com/juandspy/monkey-lang/code"
+	"github.com/juandspy/monkey-lang/token"
 )
 
 type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	LOOP_CONTROL_OBJ = "LOOP_CONTROL"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	RANGE_OBJ        = "RANGE"
+	ITERATOR_OBJ     = "ITERATOR"
+	CELL_OBJ         = "CELL"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 )
 
 // Object represents the values in the monkey lang
@@ -37,6 +48,22 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always shows a decimal point or an exponent, so that `2.0` is not
+// confused with the integer `2`
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if strings.ContainsAny(s, ".eIN") {
+		return s
+	}
+	return s + ".0"
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -56,32 +83,136 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// LoopControl is the result of a `break` or `continue` statement. Like a
+// ReturnValue, it stops the evaluation of the blocks until the loop is reached.
+type LoopControl struct {
+	Break bool // false for continue
+}
+
+func (lc *LoopControl) Type() ObjectType { return LOOP_CONTROL_OBJ }
+func (lc *LoopControl) Inspect() string {
+	if lc.Break {
+		return "break"
+	}
+	return "continue"
+}
+
 type Error struct {
 	Message string
-	// TODO: add stack trace, line number, column number, etc.
+	Kind    string         // the type of the error given to `throw`, "Error" if empty
+	Pos     token.Position // where the error happened, if known
+	// Stack has the function calls that led to the error, the innermost first
+	Stack []StackFrame
+}
+
+// StackFrame is a call to a Monkey function in the stack trace of an error
+type StackFrame struct {
+	Function string         // the name it was bound to with `let`, if any
+	Pos      token.Position // where the function was called
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string  { return "ERROR: " + e.Error() }
+
+// Error implements the error interface so that the compiler and the VM can return
+// Monkey errors as Go errors
+func (e *Error) Error() string {
+	message := e.Message
+	if e.Kind != "" {
+		message = e.Kind + ": " + message
+	}
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + message
+	}
+	return message
+}
+
+// maxRepeatedFrames is the number of identical lines of a stack trace that are
+// printed before collapsing the rest, which happens with deep recursion
+const maxRepeatedFrames = 3
+
+// StackTrace returns the calls that led to the error like a Python traceback,
+// the most recent call last, or an empty string if it didn't happen inside a
+// function. Each line has the position and the function it belongs to:
+//
+//	Traceback (most recent call last):
+//	  script.mk:9:6 in <main>
+//	  script.mk:5:10 in outer
+//	  script.mk:2:3 in inner
+func (e *Error) StackTrace() string {
+	if len(e.Stack) == 0 {
+		return ""
+	}
+	lines := []string{fmt.Sprintf("  %s in <main>\n", e.Stack[len(e.Stack)-1].Pos)}
+	for _, call := range e.calls() {
+		lines = append(lines, "  "+call+"\n")
+	}
+
+	var out bytes.Buffer
+	out.WriteString("Traceback (most recent call last):\n")
+	for i := 0; i < len(lines); {
+		repeated := 1
+		for i+repeated < len(lines) && lines[i+repeated] == lines[i] {
+			repeated++
+		}
+		for j := 0; j < repeated && j < maxRepeatedFrames; j++ {
+			out.WriteString(lines[i])
+		}
+		if repeated > maxRepeatedFrames {
+			fmt.Fprintf(&out, "  [previous line repeated %d more times]\n", repeated-maxRepeatedFrames)
+		}
+		i += repeated
+	}
+	return out.String()
+}
+
+// calls returns a line for each function call of the stack, with the function
+// and the position inside it where the next call or the error happened, the
+// most recent call last
+func (e *Error) calls() []string {
+	calls := []string{}
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		pos := e.Pos
+		if i > 0 {
+			pos = e.Stack[i-1].Pos
+		}
+		calls = append(calls, fmt.Sprintf("%s in %s", pos, functionName(e.Stack[i].Function)))
+	}
+	return calls
+}
+
+func functionName(name string) string {
+	if name == "" {
+		return "<anonymous>"
+	}
+	return name
+}
 
 type Function struct {
+	Name       string // the name it was bound to with `let`, if any
 	Parameters []*ast.Identifier
+	Defaults   []ast.Expression // see ast.FunctionLiteral
+	Rest       *ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
+	return inspectFunction(&ast.FunctionLiteral{
+		Parameters: f.Parameters, Defaults: f.Defaults, Rest: f.Rest, Body: f.Body,
+	})
+}
+
+// inspectFunction shows the source of the function, which is how the functions
+// of both engines are printed
+func inspectFunction(lit *ast.FunctionLiteral) string {
 	var out bytes.Buffer
-	params := []string{}
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
-	}
 	out.WriteString("fn")
 	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(lit.ParametersString())
 	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(lit.Body.String())
 	out.WriteString("\n}")
 	return out.String()
 }
@@ -93,13 +224,37 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
-type BuiltinFunction func(args ...Object) Object
+// BuiltinFunction is the Go function of a builtin. It gets the execution of the
+// program that calls it, with its streams.
+type BuiltinFunction func(execution *Execution, args ...Object) Object
 type Builtin struct {
-	Fn BuiltinFunction
+	Name string // the name it's registered with, like "len" or "math.sqrt"
+	// MinArgs and MaxArgs are the number of arguments it takes, MaxArgs is -1
+	// if there is no maximum. They describe the builtin, Fn checks them.
+	MinArgs, MaxArgs int
+	Fn               BuiltinFunction
+	// Allocates is set when Fn returns new strings, arrays or hashes instead of
+	// its arguments or their elements, so their memory counts towards the limit
+	Allocates bool
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
-func (b *Builtin) Inspect() string  { return "builtin function" }
+
+// Inspect shows the name and the arity of the builtin, like "builtin push/2",
+// "builtin range/1-3" or "builtin puts/0+"
+func (b *Builtin) Inspect() string {
+	if b.Name == "" {
+		return "builtin function"
+	}
+	switch {
+	case b.MaxArgs < 0:
+		return fmt.Sprintf("builtin %s/%d+", b.Name, b.MinArgs)
+	case b.MaxArgs != b.MinArgs:
+		return fmt.Sprintf("builtin %s/%d-%d", b.Name, b.MinArgs, b.MaxArgs)
+	default:
+		return fmt.Sprintf("builtin %s/%d", b.Name, b.MinArgs)
+	}
+}
 
 type Array struct {
 	Elements []Object
@@ -118,6 +273,70 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 
+// Range is a sequence of integers created by the `range` builtin. The integers
+// are not stored, so ranges can be iterated without allocating big arrays.
+type Range struct {
+	Start, Stop, Step int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.Stop)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.Stop, r.Step)
+}
+
+// Len returns the number of integers in the range
+func (r *Range) Len() int64 {
+	if r.Step > 0 && r.Start < r.Stop {
+		return (r.Stop - r.Start + r.Step - 1) / r.Step
+	}
+	if r.Step < 0 && r.Start > r.Stop {
+		return (r.Start - r.Stop - r.Step - 1) / -r.Step
+	}
+	return 0
+}
+
+// CompiledFunction is a function compiled into bytecode instructions
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int                    // not including the rest parameter
+	NumDefaults   int                    // number of parameters with a default value, always the last ones
+	Variadic      bool                   // there is a rest parameter, which is the local after the parameters
+	Name          string                 // the name it was bound to with `let`, if any
+	Positions     map[int]token.Position // instruction offset -> source position
+	Literal       *ast.FunctionLiteral   // the source, to print the function as the evaluator does
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	if cf.Literal == nil {
+		return fmt.Sprintf("CompiledFunction[%p]", cf)
+	}
+	return inspectFunction(cf.Literal)
+}
+
+// Closure wraps a CompiledFunction along with the free variables it references.
+// It's what the VM calls, so for the user it's just a FUNCTION, as in the evaluator.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string  { return c.Fn.Inspect() }
+
+// Cell holds the value of a local variable captured by a closure, so that the
+// function and the closure share it and see the assignments made by the other
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
+
 // HashKey is needed in order to compare hash keys in our hash structure. Otherwise, as you
 // would be comparing pointers to strings, it would never return true.
 // See TestStringHashKey for a better understanding.
@@ -138,6 +357,9 @@ func (b *Boolean) HashKey() HashKey {
 func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
+func (f *Float) HashKey() HashKey {
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -169,3 +391,20 @@ func (h *Hash) Inspect() string {
 type Hashable interface {
 	HashKey() HashKey
 }
+
+// CheckArguments returns an error if a function can't be called with numArgs
+// arguments. It has numParams parameters, the last numDefaults of them with a
+// default value, plus a rest parameter if it's variadic.
+func CheckArguments(numParams, numDefaults int, variadic bool, numArgs int) *Error {
+	required := numParams - numDefaults
+	switch {
+	case numArgs >= required && (numArgs <= numParams || variadic):
+		return nil
+	case variadic:
+		return newError("wrong number of arguments: want=%d or more, got=%d", required, numArgs)
+	case numDefaults > 0:
+		return newError("wrong number of arguments: want=%d to %d, got=%d", required, numParams, numArgs)
+	default:
+		return newError("wrong number of arguments: want=%d, got=%d", numParams, numArgs)
+	}
+}
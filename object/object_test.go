@@ -1,6 +1,13 @@
 package object
 
-import "testing"
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/juandspy/monkey-lang/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -18,3 +25,306 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-3, "-3.0"},
+		{1e21, "1e+21"},
+		{0.001, "0.001"},
+	}
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestIterator(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, key := range []Object{&String{Value: "b"}, &Integer{Value: 10},
+		&Integer{Value: 2}, &String{Value: "a"}, &Boolean{Value: true}} {
+		hash.Pairs[key.(Hashable).HashKey()] = HashPair{Key: key, Value: key}
+	}
+
+	tests := []struct {
+		iterable Object
+		expected []string
+	}{
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}, []string{"1", "a"}},
+		{&String{Value: "añb"}, []string{"a", "ñ", "b"}},
+		{hash, []string{"true", "2", "10", "a", "b"}},
+		{&Range{Start: 0, Stop: 3, Step: 1}, []string{"0", "1", "2"}},
+		{&Range{Start: 5, Stop: 0, Step: -2}, []string{"5", "3", "1"}},
+		{&Range{Start: 3, Stop: 0, Step: 1}, []string{}},
+	}
+	for _, tt := range tests {
+		iterator, ok := NewIterator(tt.iterable)
+		if !ok {
+			t.Errorf("%s is not iterable", tt.iterable.Inspect())
+			continue
+		}
+		got := []string{}
+		for element, ok := iterator.Next(); ok; element, ok = iterator.Next() {
+			got = append(got, element.Inspect())
+		}
+		if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("wrong elements for %s. want=%v, got=%v", tt.iterable.Inspect(), tt.expected, got)
+		}
+	}
+
+	if _, ok := NewIterator(&Integer{Value: 1}); ok {
+		t.Errorf("integers must not be iterable")
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	pos := func(line, column int) token.Position {
+		return token.Position{Filename: "script.mk", Line: line, Column: column}
+	}
+	err := &Error{Message: "boom", Pos: pos(1, 20)}
+	if err.StackTrace() != "" {
+		t.Errorf("expected no stack trace outside functions, got=%q", err.StackTrace())
+	}
+
+	// f calls itself 5 times from main and then fails
+	err.Stack = []StackFrame{}
+	for i := 0; i < 5; i++ {
+		err.Stack = append(err.Stack, StackFrame{Function: "f", Pos: pos(1, 10)})
+	}
+	err.Stack = append(err.Stack, StackFrame{Function: "f", Pos: pos(2, 2)})
+	expected := "Traceback (most recent call last):\n" +
+		"  script.mk:2:2 in <main>\n" +
+		"  script.mk:1:10 in f\n" +
+		"  script.mk:1:10 in f\n" +
+		"  script.mk:1:10 in f\n" +
+		"  [previous line repeated 2 more times]\n" +
+		"  script.mk:1:20 in f\n"
+	if err.StackTrace() != expected {
+		t.Errorf("wrong stack trace.\nexpected=%q\ngot=     %q", expected, err.StackTrace())
+	}
+}
+
+func TestThrownError(t *testing.T) {
+	hash := func(pairs ...string) *Hash {
+		h := &Hash{Pairs: map[HashKey]HashPair{}}
+		for i := 0; i < len(pairs); i += 2 {
+			key := &String{Value: pairs[i]}
+			h.Pairs[key.HashKey()] = HashPair{Key: key, Value: &String{Value: pairs[i+1]}}
+		}
+		return h
+	}
+	tests := []struct {
+		value    Object
+		expected string
+	}{
+		{&String{Value: "bad input"}, "bad input"},
+		{hash("message", "bad input"), "bad input"},
+		{hash("message", "bad input", "type", "ValueError"), "ValueError: bad input"},
+		{hash("type", "ValueError"), `a thrown hash needs a "message" STRING`},
+		{hash("message", "bad input", "type", "LimitError"), "cannot throw an error of type LimitError"},
+		{&Integer{Value: 1}, "cannot throw INTEGER, want STRING or HASH"},
+	}
+	for _, tt := range tests {
+		err := NewThrownError(tt.value)
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %s. want=%q, got=%q", tt.value.Inspect(), tt.expected, err.Error())
+		}
+	}
+
+	original := &Error{Message: "boom"}
+	if NewThrownError(original) != original {
+		t.Errorf("throwing an error must raise it again")
+	}
+}
+
+func TestErrorValue(t *testing.T) {
+	err := &Error{
+		Message: "boom",
+		Kind:    "ValueError",
+		Pos:     token.Position{Filename: "script.mk", Line: 1, Column: 20},
+		Stack:   []StackFrame{{Function: "", Pos: token.Position{Filename: "script.mk", Line: 2, Column: 2}}},
+	}
+	expected := map[string]string{
+		"message":  "boom",
+		"type":     "ValueError",
+		"position": "script.mk:1:20",
+		"stack":    "[script.mk:1:20 in <anonymous>]",
+	}
+	value := err.Value()
+	if len(value.Pairs) != len(expected) {
+		t.Fatalf("wrong number of fields. want=%d, got=%d", len(expected), len(value.Pairs))
+	}
+	for key, want := range expected {
+		pair, ok := value.Pairs[(&String{Value: key}).HashKey()]
+		if !ok {
+			t.Errorf("missing field %q", key)
+			continue
+		}
+		if pair.Value.Inspect() != want {
+			t.Errorf("wrong %q. want=%q, got=%q", key, want, pair.Value.Inspect())
+		}
+	}
+
+	if kind := (&Error{Message: "boom"}).Value().Pairs[(&String{Value: "type"}).HashKey()]; kind.Value.Inspect() != "Error" {
+		t.Errorf("the default type must be Error, got=%q", kind.Value.Inspect())
+	}
+}
+
+func TestExecution(t *testing.T) {
+	var unlimited Execution
+	for i := 0; i < 5000; i++ {
+		if err := unlimited.Step(); err != nil {
+			t.Fatalf("the zero value must have no limits, got=%q", err.Error())
+		}
+	}
+	if err := unlimited.CheckDepth(1 << 20); err != nil {
+		t.Errorf("the zero value must have no depth limit, got=%q", err.Error())
+	}
+
+	execution, cancel := NewExecution(context.Background(), Limits{MaxSteps: 2, MaxDepth: 3})
+	defer cancel()
+	if execution.Step() != nil || execution.Step() != nil {
+		t.Fatalf("the first steps must be allowed")
+	}
+	if err := execution.Step(); err == nil || err.Error() != "LimitError: step limit exceeded: 2" {
+		t.Errorf("wrong step limit error, got=%v", err)
+	}
+	if err := execution.CheckDepth(3); err != nil {
+		t.Errorf("depth 3 must be allowed, got=%q", err.Error())
+	}
+	if err := execution.CheckDepth(4); err == nil || err.Kind != LimitError {
+		t.Errorf("expected a depth limit error, got=%v", err)
+	}
+	if usage := execution.Usage(); usage.Steps != 3 || usage.Depth != 4 {
+		t.Errorf("wrong usage, got=%+v", usage)
+	}
+}
+
+func TestEnvironmentNames(t *testing.T) {
+	outer := NewEnvironment()
+	outer.Set("z", &Integer{Value: 1})
+	env := NewEnclosedEnvironment(outer)
+	env.Set("b", &Integer{Value: 2})
+	env.Set("a", &Integer{Value: 3})
+
+	names := env.Names()
+	if len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("wrong names. want=[a b], got=%v", names)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		obj      Object
+		expected int64
+	}{
+		{&String{Value: "hello"}, 21},
+		{&Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}}, 56},
+		{&Hash{Pairs: map[HashKey]HashPair{}}, 48},
+		{&Integer{Value: 1}, 0},
+	}
+	for _, tt := range tests {
+		if size := SizeOf(tt.obj); size != tt.expected {
+			t.Errorf("wrong size of %s. expected=%d, got=%d", tt.obj.Inspect(), tt.expected, size)
+		}
+	}
+
+	execution, cancel := NewExecution(context.Background(), Limits{MaxMemory: 40})
+	defer cancel()
+	if err := execution.Allocate(&String{Value: "hello"}); err != nil {
+		t.Fatalf("the first string must be allowed, got=%q", err.Error())
+	}
+	if err := execution.Allocate(&String{Value: "hello"}); err == nil || err.Error() != "LimitError: memory limit exceeded: 40 bytes" {
+		t.Errorf("wrong memory limit error, got=%v", err)
+	}
+	if usage := execution.Usage(); usage.Allocated != 42 || usage.Memory != 42 {
+		t.Errorf("wrong usage. expected=42 bytes allocated and in use, got=%+v", usage)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	streams := NewStreams(strings.NewReader("one\r\n\ntwo"), nil, nil)
+	for _, expected := range []string{"one", "", "two"} {
+		line, err := streams.ReadLine()
+		if err != nil || line != expected {
+			t.Errorf("wrong line. expected=%q, got=%q (%v)", expected, line, err)
+		}
+	}
+	if _, err := streams.ReadLine(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got=%v", err)
+	}
+
+	var execution *Execution
+	if execution.Streams() != defaultStreams {
+		t.Errorf("a nil execution must use the streams of the process")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if builtin, ok := r.Lookup("len"); !ok || builtin.Inspect() != "builtin len/1" {
+		t.Errorf("len is not registered, got=%v", builtin)
+	}
+	for name, expected := range map[string]string{"range": "builtin range/1-3", "puts": "builtin puts/0+"} {
+		if builtin, _ := r.Lookup(name); builtin.Inspect() != expected {
+			t.Errorf("wrong inspect of %s. expected=%q, got=%q", name, expected, builtin.Inspect())
+		}
+	}
+	if inspect := (&Builtin{}).Inspect(); inspect != "builtin function" {
+		t.Errorf("wrong inspect of an anonymous builtin, got=%q", inspect)
+	}
+
+	math, ok := r.Lookup("math")
+	hash, isHash := math.(*Hash)
+	if !ok || !isHash {
+		t.Fatalf("math is not a namespace, got=%v", math)
+	}
+	if _, ok := hash.Pairs[(&String{Value: "sqrt"}).HashKey()]; !ok {
+		t.Errorf("math.sqrt is not registered")
+	}
+
+	names := r.Names()
+	double := &Builtin{MinArgs: 1, MaxArgs: 1}
+	r.Register("double", double)
+	r.Register("math.double", double)
+	if double.Name != "double" {
+		t.Errorf("the builtin must get the first name, got=%q", double.Name)
+	}
+	r.Remove("puts", "math.sqrt")
+	if _, ok := r.Lookup("puts"); ok {
+		t.Errorf("puts must be removed")
+	}
+	if _, ok := hash.Pairs[(&String{Value: "sqrt"}).HashKey()]; ok {
+		t.Errorf("math.sqrt must be removed")
+	}
+	if _, ok := hash.Pairs[(&String{Value: "double"}).HashKey()]; !ok {
+		t.Errorf("math.double is not registered")
+	}
+
+	// the indexes never change, so that the compiled programs stay valid
+	newNames := r.Names()
+	for i, name := range names {
+		if name == "puts" {
+			name = ""
+		}
+		if newNames[i] != name {
+			t.Errorf("wrong name %d. expected=%q, got=%q", i, name, newNames[i])
+		}
+	}
+	if last := newNames[len(newNames)-1]; last != "double" {
+		t.Errorf("wrong last name. expected=%q, got=%q", "double", last)
+	}
+	if globals := r.Globals(); globals[len(globals)-1] != double {
+		t.Errorf("wrong last global, got=%v", globals[len(globals)-1])
+	}
+
+	if names := NewEmptyRegistry().Names(); len(names) != 0 {
+		t.Errorf("an empty registry has builtins: %v", names)
+	}
+}
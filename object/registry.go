@@ -0,0 +1,127 @@
+package object
+
+import "strings"
+
+// Registry is the set of builtin functions available to a program. Each
+// interpreter has its own, so that a host can add functions or disable some of
+// them without changing the other interpreters.
+//
+// A name with a dot, like "math.sqrt", registers the function in a namespace:
+// the global "math" is a hash with the functions of the namespace, which the
+// programs call with `math.sqrt(2)`.
+type Registry struct {
+	// globals are the builtins and the hashes of the namespaces. The compiler
+	// refers to them by their index, so they are never moved: removing one
+	// leaves a nil behind.
+	globals []Object
+	names   []string
+	index   map[string]int
+}
+
+// NewRegistry returns a Registry with the standard builtins
+func NewRegistry() *Registry {
+	r := NewEmptyRegistry()
+	for _, builtin := range Builtins {
+		r.Register(builtin.Name, builtin)
+	}
+	return r
+}
+
+// NewEmptyRegistry returns a Registry without builtins, for the hosts that
+// choose every function available to the programs
+func NewEmptyRegistry() *Registry {
+	return &Registry{index: map[string]int{}}
+}
+
+// Register adds the builtin with the given name, replacing the one that had
+// it, if any. The builtin gets the name if it doesn't have one.
+func (r *Registry) Register(name string, builtin *Builtin) {
+	if builtin.Name == "" {
+		builtin.Name = name
+	}
+	namespace, function, ok := splitNamespace(name)
+	if !ok {
+		r.setGlobal(name, builtin)
+		return
+	}
+	hash, ok := r.namespace(namespace)
+	if !ok {
+		hash = &Hash{Pairs: map[HashKey]HashPair{}}
+		r.setGlobal(namespace, hash)
+	}
+	key := &String{Value: function}
+	hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: builtin}
+}
+
+// Remove disables the builtins with the given names. The name of a namespace
+// removes all its functions.
+func (r *Registry) Remove(names ...string) {
+	for _, name := range names {
+		namespace, function, ok := splitNamespace(name)
+		if !ok {
+			if i, ok := r.index[name]; ok {
+				r.globals[i] = nil
+				delete(r.index, name)
+			}
+			continue
+		}
+		if hash, ok := r.namespace(namespace); ok {
+			delete(hash.Pairs, (&String{Value: function}).HashKey())
+		}
+	}
+}
+
+// Lookup returns the builtin or the namespace bound to the global name
+func (r *Registry) Lookup(name string) (Object, bool) {
+	i, ok := r.index[name]
+	if !ok {
+		return nil, false
+	}
+	return r.globals[i], true
+}
+
+// Names returns the global names of the builtins and the namespaces, in the
+// order of their index. Removed ones are empty.
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.names))
+	for i, name := range r.names {
+		if r.globals[i] != nil {
+			names[i] = name
+		}
+	}
+	return names
+}
+
+// Globals returns the builtins and the namespaces, in the order of their index.
+// Removed ones are nil.
+func (r *Registry) Globals() []Object {
+	return append([]Object{}, r.globals...)
+}
+
+func (r *Registry) setGlobal(name string, value Object) {
+	if i, ok := r.index[name]; ok {
+		r.globals[i] = value
+		return
+	}
+	// a removed name gets a new index, the old one may be compiled already
+	r.index[name] = len(r.globals)
+	r.globals = append(r.globals, value)
+	r.names = append(r.names, name)
+}
+
+func (r *Registry) namespace(name string) (*Hash, bool) {
+	global, ok := r.Lookup(name)
+	if !ok {
+		return nil, false
+	}
+	hash, ok := global.(*Hash)
+	return hash, ok
+}
+
+func splitNamespace(name string) (namespace, function string, ok bool) {
+	i := strings.Index(name, ".")
+	if i < 0 {
+		return "", name, false
+	}
+	return name[:i], name[i+1:], true
+}
@@ -0,0 +1,45 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// Streams are the standard input and outputs of a program, used by builtins
+// like `puts` and `input`. Each session can have its own, to capture the
+// output of the programs.
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	lines *bufio.Reader // reads Stdin, kept between reads so that no input is lost
+}
+
+// NewStreams returns the Streams with the given input and outputs
+func NewStreams(stdin io.Reader, stdout, stderr io.Writer) *Streams {
+	return &Streams{Stdin: stdin, Stdout: stdout, Stderr: stderr}
+}
+
+// defaultStreams are the ones of the process
+var defaultStreams = NewStreams(os.Stdin, os.Stdout, os.Stderr)
+
+// ReadLine reads the next line of Stdin, without the line break. It returns
+// io.EOF when there are no more lines.
+func (s *Streams) ReadLine() (string, error) {
+	if s.lines == nil {
+		s.lines = bufio.NewReader(s.Stdin)
+	}
+	line, err := s.lines.ReadString('\n')
+	if err == io.EOF && line != "" {
+		// the last line doesn't end with a line break
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
@@ -0,0 +1,77 @@
+package object
+
+// NewThrownError returns the error raised by `throw value`. The value is either
+// a string with the message or a hash with a "message" and, optionally, a
+// "type", like the ones bound by `catch`. The type can't be LimitError, which
+// is reserved for the Execution, as those errors can't be caught. Throwing an
+// *Error raises it again as it is, which the VM does to run the finally blocks.
+func NewThrownError(value Object) *Error {
+	switch value := value.(type) {
+	case *Error:
+		return value
+	case *String:
+		return &Error{Message: value.Value}
+	case *Hash:
+		message, ok := hashString(value, "message")
+		if !ok {
+			return newError("a thrown hash needs a \"message\" STRING")
+		}
+		kind, _ := hashString(value, "type")
+		switch kind {
+		case LimitError:
+			return newError("cannot throw an error of type %s", LimitError)
+		case "Error":
+			// the type that `catch` gives to the errors without one, so that
+			// rethrowing them doesn't add it to the message
+			kind = ""
+		}
+		return &Error{Message: message, Kind: kind}
+	default:
+		return newError("cannot throw %s, want STRING or HASH", value.Type())
+	}
+}
+
+func hashString(hash *Hash, key string) (string, bool) {
+	pair, ok := hash.Pairs[(&String{Value: key}).HashKey()]
+	if !ok {
+		return "", false
+	}
+	str, ok := pair.Value.(*String)
+	if !ok {
+		return "", false
+	}
+	return str.Value, true
+}
+
+// Value returns the hash that `catch` binds to its variable. It has the
+// "message", the "type" of the error, the "position" where it happened and the
+// "stack" of calls between the `try` and the error, the most recent call last.
+func (e *Error) Value() *Hash {
+	kind := e.Kind
+	if kind == "" {
+		kind = "Error"
+	}
+	position := ""
+	if e.Pos.IsValid() {
+		position = e.Pos.String()
+	}
+	stack := &Array{Elements: []Object{}}
+	for _, call := range e.calls() {
+		stack.Elements = append(stack.Elements, &String{Value: call})
+	}
+
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	for _, field := range []struct {
+		key   string
+		value Object
+	}{
+		{"message", &String{Value: e.Message}},
+		{"type", &String{Value: kind}},
+		{"position", &String{Value: position}},
+		{"stack", stack},
+	} {
+		key := &String{Value: field.key}
+		hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: field.value}
+	}
+	return hash
+}
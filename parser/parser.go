@@ -13,26 +13,45 @@ const (
 	// Preference order:
 	_ int = iota
 	LOWEST
+	OR          // ||
+	AND         // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
+	BITOR       // |
+	BITXOR      // ^
+	BITAND      // &
+	SHIFT       // << or >>
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
+	POWER       // **
 	CALL        // myFunction(X)
-	INDEX       // myArray[index]
+	INDEX       // myArray[index] or namespace.name
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.OR:        OR,
+	token.AND:       AND,
+	token.EQ:        EQUALS,
+	token.NOT_EQ:    EQUALS,
+	token.LT:        LESSGREATER,
+	token.GT:        LESSGREATER,
+	token.LT_EQ:     LESSGREATER,
+	token.GT_EQ:     LESSGREATER,
+	token.PIPE:      BITOR,
+	token.CARET:     BITXOR,
+	token.AMPERSAND: BITAND,
+	token.SHL:       SHIFT,
+	token.SHR:       SHIFT,
+	token.PLUS:      SUM,
+	token.MINUS:     SUM,
+	token.SLASH:     PRODUCT,
+	token.ASTERISK:  PRODUCT,
+	token.PERCENT:   PRODUCT,
+	token.POWER:     POWER,
+	token.LPAREN:    CALL,
+	token.LBRACKET:  INDEX,
+	token.DOT:       INDEX,
 }
 
 type (
@@ -41,12 +60,32 @@ type (
 	infixParseFn func(ast.Expression) ast.Expression
 )
 
+// Error is a problem found while parsing. Expected and Got are set when the
+// parser was expecting a different token.
+type Error struct {
+	Pos      token.Position
+	Expected token.TokenType
+	Got      token.TokenType
+	Message  string
+}
+
+// Error returns the message along with the position, like
+// `script.mk:1:5: expected next token to be =, got INT instead`
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Message
+}
+
 // Parser works similarly to Lexer, but reading tokens instead of characters
 type Parser struct {
 	l         *lexer.Lexer
 	curToken  token.Token // current token
 	peekToken token.Token // next token
-	errors    []string    // errors found during the parsing
+	errors    []*Error    // errors found during the parsing
+
+	lexerErrors int  // number of lexer errors already added to errors
+	panicking   bool // an error was found and the statement is being skipped
+	skipped     int  // number of broken statements skipped so far
+	loopDepth   int  // number of loops enclosing the current token in the function
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -55,16 +94,21 @@ type Parser struct {
 // New returns a pointer to a Parser that has been initialized calling `nextToken`
 // twice so that curToken and peekToken are both set
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: []*Error{}}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.WHILE, p.parseWhileExpression)
+	p.registerPrefix(token.FOR, p.parseForExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
@@ -80,29 +124,73 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseDotExpression)
 
 	p.nextToken()
 	p.nextToken()
 	return p
 }
 
-// Errors return the parse errors
-func (p *Parser) Errors() []string {
+// Errors return the parse errors, sorted as they were found
+func (p *Parser) Errors() []*Error {
 	return p.errors
 }
 
+// addError stores an error unless the parser is already skipping a broken
+// statement, as the following errors would most likely be caused by the first one
+func (p *Parser) addError(err *Error) {
+	if p.panicking {
+		return
+	}
+	p.panicking = true
+	for _, e := range p.errors {
+		if e.Pos == err.Pos && e.Message == err.Message {
+			return
+		}
+	}
+	p.errors = append(p.errors, err)
+}
+
 // peekError stores an error for an unexpected type for the next (peek) token
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	if p.peekTokenIs(token.ILLEGAL) {
+		// the lexer has already reported why the token is illegal
+		p.panicking = true
+		return
+	}
+	p.addError(&Error{
+		Pos:      p.peekToken.Pos,
+		Expected: t,
+		Got:      p.peekToken.Type,
+		Message: fmt.Sprintf("expected next token to be %s, got %s instead",
+			t, p.peekToken.Type),
+	})
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	// keep the lexer errors in the same order as the tokens
+	if lexerErrors := p.l.Errors(); len(lexerErrors) > p.lexerErrors {
+		for _, e := range lexerErrors[p.lexerErrors:] {
+			p.errors = append(p.errors, &Error{Pos: e.Pos, Got: token.ILLEGAL, Message: e.Message})
+		}
+		p.lexerErrors = len(lexerErrors)
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -110,19 +198,73 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
-		program.Statements = append(program.Statements, stmt)
+		if stmt, _ := p.parseStatementOrSkip(); stmt != nil {
+			program.Statements = append(program.Statements, stmt)
+		}
 		p.nextToken()
 	}
 	return program
 }
 
+// parseStatementOrSkip parses a statement. If it's broken, the tokens up to the
+// end of the statement are skipped and nil is returned, so that the parsing can
+// go on with the next one. It also returns true if the skipping stopped at the
+// brace closing the enclosing block.
+func (p *Parser) parseStatementOrSkip() (ast.Statement, bool) {
+	skipped := p.skipped
+	stmt := p.parseStatement()
+	if p.panicking {
+		return nil, p.synchronize()
+	}
+	if p.skipped > skipped {
+		// a nested block recovered from an error, but this statement is incomplete
+		return nil, false
+	}
+	return stmt, false
+}
+
+// synchronize skips the tokens of a broken statement. It stops at the semicolon
+// closing it, before the next `let` or `return`, or before the brace closing
+// the enclosing block. Nested blocks are skipped entirely. It returns true if
+// the current token is already the brace closing the enclosing block.
+func (p *Parser) synchronize() bool {
+	p.panicking = false
+	p.skipped++
+	depth := 0
+	for !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				return true
+			}
+			depth--
+		case token.SEMICOLON:
+			if depth == 0 {
+				return false
+			}
+		}
+		if depth == 0 && (p.peekTokenIs(token.LET) ||
+			p.peekTokenIs(token.RETURN) || p.peekTokenIs(token.THROW) ||
+			p.peekTokenIs(token.RBRACE)) {
+			return false
+		}
+		p.nextToken()
+	}
+	return false
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -146,6 +288,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		// remember the name so that the function can refer to itself once compiled
+		fl.Name = stmt.Name.Value
+	}
 	if p.peekTokenIs(token.SEMICOLON) {
 		// escape the semicolon before moving on
 		p.nextToken()
@@ -166,9 +312,83 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseLoopControlStatement parses `break` and `continue`, which are only
+// allowed inside the body of a loop
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	tok := p.curToken
+	if p.loopDepth == 0 {
+		p.addError(&Error{
+			Pos:     tok.Pos,
+			Got:     tok.Type,
+			Message: fmt.Sprintf("%s is not in a loop", tok.Literal),
+		})
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
+// assignmentOperators are the tokens that turn an expression statement into an
+// assignment, like `x = 1` or `x += 1`
+var assignmentOperators = map[token.TokenType]bool{
+	token.ASSIGN:          true,
+	token.PLUS_ASSIGN:     true,
+	token.MINUS_ASSIGN:    true,
+	token.ASTERISK_ASSIGN: true,
+	token.SLASH_ASSIGN:    true,
+}
+
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 	stmt.Expression = p.parseExpression(LOWEST)
+	if assignmentOperators[p.peekToken.Type] {
+		return p.parseAssignStatement(stmt.Expression)
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseAssignStatement parses the value assigned to the target, which has
+// already been parsed as an expression
+func (p *Parser) parseAssignStatement(target ast.Expression) ast.Statement {
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		if target != nil {
+			p.addError(&Error{
+				Pos:     target.Pos(),
+				Message: fmt.Sprintf("cannot assign to %s", target.String()),
+			})
+		}
+		return nil
+	}
+
+	p.nextToken()
+	stmt := &ast.AssignStatement{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -198,8 +418,16 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		// the lexer has already reported why the token is illegal
+		p.panicking = true
+		return
+	}
+	p.addError(&Error{
+		Pos:     p.curToken.Pos,
+		Got:     t,
+		Message: fmt.Sprintf("no prefix parse function for %s found", t),
+	})
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
@@ -210,8 +438,26 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(&Error{
+			Pos:     p.curToken.Pos,
+			Got:     p.curToken.Type,
+			Message: fmt.Sprintf("could not parse %q as integer", p.curToken.Literal),
+		})
+		return nil
+	}
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(&Error{
+			Pos:     p.curToken.Pos,
+			Got:     p.curToken.Type,
+			Message: fmt.Sprintf("could not parse %q as float", p.curToken.Literal),
+		})
 		return nil
 	}
 	lit.Value = value
@@ -235,6 +481,10 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 		Left:     left,
 	}
 	precedence := p.curPrecedence()
+	if p.curTokenIs(token.POWER) {
+		// `**` is right associative: `2 ** 3 ** 2` is `2 ** (3 ** 2)`
+		precedence--
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 	return expression
@@ -282,6 +532,96 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseWhileExpression() ast.Expression {
+	expression := &ast.WhileExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	expression.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Body = p.parseLoopBody()
+	return expression
+}
+
+func (p *Parser) parseForExpression() ast.Expression {
+	expression := &ast.ForExpression{Token: p.curToken}
+
+	// the expression is like `for (x in iterable) {...`
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	expression.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	expression.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Body = p.parseLoopBody()
+	return expression
+}
+
+// parseTryExpression parses `try {...} catch (e) {...} finally {...}`, where
+// either the catch or the finally block can be left out
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		expression.CatchVariable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+	if expression.Catch == nil && expression.Finally == nil {
+		p.addError(&Error{
+			Pos:     p.peekToken.Pos,
+			Got:     p.peekToken.Type,
+			Message: "try needs a catch or a finally block",
+		})
+		return nil
+	}
+	return expression
+}
+
+// parseLoopBody parses the block of a loop, where break and continue are allowed
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+	return p.parseBlockStatement()
+}
+
 // curTokenIs checks whether the current token is of a given type
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
@@ -289,7 +629,14 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		block.Statements = append(block.Statements, p.parseStatement())
+		stmt, blockEnd := p.parseStatementOrSkip()
+		if blockEnd {
+			// the broken statement was the last one of the block
+			break
+		}
+		if stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
 		p.nextToken()
 	}
 	return block
@@ -300,39 +647,70 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	lit.Parameters = p.parseFunctionParameters()
+	if !p.parseFunctionParameters(lit) {
+		return nil
+	}
 	// parseFunctionParameters already escaped the RPAREN
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
+	// break and continue can't jump out of the function
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = loopDepth
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
+// parseFunctionParameters parses the parameters of the function literal, like
+// `(a, b = 10, ...rest)`. The parameters with a default value must come after
+// the required ones, and the rest parameter must be the last one.
+func (p *Parser) parseFunctionParameters(lit *ast.FunctionLiteral) bool {
+	lit.Parameters = []*ast.Identifier{}
 
 	if p.peekTokenIs(token.RPAREN) {
 		// no parameters
 		p.nextToken()
-		return identifiers
+		return true
 	}
 
-	p.nextToken()
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+	for {
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			return p.expectPeek(token.RPAREN)
+		}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken() // escape the previous identifier
-		p.nextToken() // escape the comma
+		if !p.expectPeek(token.IDENT) {
+			return false
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
-	}
-	if !p.expectPeek(token.RPAREN) {
-		return nil
-	}
+		lit.Parameters = append(lit.Parameters, ident)
+
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken() // escape the identifier
+			p.nextToken() // escape the =
+			for len(lit.Defaults) < len(lit.Parameters)-1 {
+				lit.Defaults = append(lit.Defaults, nil)
+			}
+			lit.Defaults = append(lit.Defaults, p.parseExpression(LOWEST))
+		} else if len(lit.Defaults) > 0 {
+			p.addError(&Error{
+				Pos:     ident.Pos(),
+				Message: fmt.Sprintf("required parameter %s follows a parameter with a default value", ident.Value),
+			})
+			return false
+		}
 
-	return identifiers
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	return p.expectPeek(token.RPAREN)
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -389,6 +767,17 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parseDotExpression parses `left.name`, which is the same as `left["name"]`,
+// used to call the functions of a namespace like `math.sqrt(2)`
+func (p *Parser) parseDotExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Index = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = make(map[ast.Expression]ast.Expression)
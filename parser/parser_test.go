@@ -6,6 +6,7 @@ import (
 
 	"github.com/juandspy/monkey-lang/ast"
 	"github.com/juandspy/monkey-lang/lexer"
+	"github.com/juandspy/monkey-lang/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -42,6 +43,26 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestProgramWithComments(t *testing.T) {
+	input := `
+// the answer
+let x = /* not 41 */ 42; // trailing comment
+/* the end */`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+	if !testLetStatement(t, program.Statements[0], "x") {
+		return
+	}
+	testLiteralExpression(t, program.Statements[0].(*ast.LetStatement).Value, 42)
+}
+
 func TestLetStatementsWithAParseError(t *testing.T) {
 	input := `
    	let foobar thiswillfail;
@@ -54,6 +75,139 @@ func TestLetStatementsWithAParseError(t *testing.T) {
 	}
 }
 
+func TestParseErrorsPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x 5;", "script.mk:1:7: expected next token to be =, got INT instead"},
+		{"let x = 5;\nlet = 10;", "script.mk:2:5: expected next token to be IDENT, got = instead"},
+		{"1 +\n  ;", "script.mk:2:3: no prefix parse function for ; found"},
+		{"let s = \"foo;\nlet x = 1;", "script.mk:1:9: unterminated string literal"},
+		{"break;", "script.mk:1:1: break is not in a loop"},
+		{"while (true) { fn() { continue; } }", "script.mk:1:23: continue is not in a loop"},
+		{"for (x 5) { x }", "script.mk:1:8: expected next token to be IN, got INT instead"},
+		{"1 = 2;", "script.mk:1:1: cannot assign to 1"},
+		{"f() += 2;", "script.mk:1:2: cannot assign to f()"},
+		{"fn(a, 1) {}", "script.mk:1:7: expected next token to be IDENT, got INT instead"},
+		{"fn(a = 1, b) {}", "script.mk:1:11: required parameter b follows a parameter with a default value"},
+		{"fn(...a, b) {}", "script.mk:1:8: expected next token to be ), got , instead"},
+		{"try { 1 };", "script.mk:1:10: try needs a catch or a finally block"},
+		{"try { 1 } catch e { e }", "script.mk:1:17: expected next token to be (, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewFile("script.mk", tt.input)
+		p := New(l)
+		_ = p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Errorf("expected 1 parser error for %q, got %d: %v", tt.input, len(errors), errors)
+			continue
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, errors[0])
+		}
+	}
+}
+
+func TestParseErrorsRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"let x 5;\nlet y = (1 + ;\nlet = 3;\nlet z = 4;",
+			[]string{
+				"1:7: expected next token to be =, got INT instead",
+				"2:14: no prefix parse function for ; found",
+				"3:5: expected next token to be IDENT, got = instead",
+			},
+		},
+		{
+			"let f = fn(x) {\n  let y x;\n  return y;\n};\nlet z = * 2;",
+			[]string{
+				"2:9: expected next token to be =, got IDENT instead",
+				"5:9: no prefix parse function for * found",
+			},
+		},
+		{
+			"if (x +) { return 1; }\nlet y 2;",
+			[]string{
+				"1:8: no prefix parse function for ) found",
+				"2:7: expected next token to be =, got INT instead",
+			},
+		},
+		{
+			"let x = €;\nlet y 2;",
+			[]string{
+				"1:9: illegal character '€'",
+				"2:7: expected next token to be =, got INT instead",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		_ = p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong number of errors for %q. expected=%d, got=%d: %v",
+				tt.input, len(tt.expected), len(errors), errors)
+			continue
+		}
+		for i, expected := range tt.expected {
+			if errors[i].Error() != expected {
+				t.Errorf("wrong error %d. expected=%q, got=%q", i, expected, errors[i])
+			}
+		}
+	}
+}
+
+// TestParseErrorsKeptStatements checks that only the broken statements are
+// dropped, even when the lexer finds an error in the token read after a valid one
+func TestParseErrorsKeptStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1\n\"abc", "let x = 1;"},
+		{"let x = 1; €", "let x = 1;"},
+		{"if (x) { € }; 2", "2"},
+		{"if (x) { 1 }\n€", "ifx 1"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 1 {
+			t.Errorf("wrong number of errors for %q. expected=1, got=%d: %v",
+				tt.input, len(p.Errors()), p.Errors())
+		}
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestParseErrorFields(t *testing.T) {
+	p := New(lexer.New("let x 5;"))
+	_ = p.ParseProgram()
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errors))
+	}
+	err := errors[0]
+	if err.Expected != token.ASSIGN || err.Got != token.INT {
+		t.Errorf("wrong tokens. expected=(%s, %s), got=(%s, %s)",
+			token.ASSIGN, token.INT, err.Expected, err.Got)
+	}
+	expectedPos := token.Position{Line: 1, Column: 7, Offset: 6}
+	if err.Pos != expectedPos {
+		t.Errorf("wrong position. expected=%+v, got=%+v", expectedPos, err.Pos)
+	}
+}
+
 // testLetStatement checks that the statement is assigned to the expected identifier
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "let" {
@@ -80,6 +234,46 @@ func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	return true
 }
 
+func TestAssignStatements(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedTarget   string
+		expectedOperator string
+		expectedValue    string
+	}{
+		{"x = 5;", "x", "=", "5"},
+		{"x += y * 2", "x", "+=", "(y * 2)"},
+		{"x -= 1;", "x", "-=", "1"},
+		{"x *= 2;", "x", "*=", "2"},
+		{"x /= 2;", "x", "/=", "2"},
+		{"arr[i + 1] = fn(x) { x };", "(arr[(i + 1)])", "=", "fn(x) x"},
+		{`h["a"] += 1`, "(h[a])", "+=", "1"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d", len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.AssignStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.AssignStatement. got=%T", program.Statements[0])
+		}
+		if stmt.Target.String() != tt.expectedTarget {
+			t.Errorf("wrong target. expected=%q, got=%q", tt.expectedTarget, stmt.Target.String())
+		}
+		if stmt.Operator != tt.expectedOperator {
+			t.Errorf("wrong operator. expected=%q, got=%q", tt.expectedOperator, stmt.Operator)
+		}
+		if stmt.Value.String() != tt.expectedValue {
+			t.Errorf("wrong value. expected=%q, got=%q", tt.expectedValue, stmt.Value.String())
+		}
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input         string
@@ -156,6 +350,37 @@ func TestIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5;", 1.5},
+		{".5;", 0.5},
+		{"1e-3;", 0.001},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input           string
@@ -355,6 +580,146 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestWhileExpression(t *testing.T) {
+	input := `while (x < y) { x; break; }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.WhileExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.WhileExpression. got=%T",
+			stmt.Expression)
+	}
+	if !testInfixExpression(t, exp.Condition, "x", "<", "y") {
+		return
+	}
+	if len(exp.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d\n", len(exp.Body.Statements))
+	}
+	if _, ok := exp.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[1] is not ast.BreakStatement. got=%T",
+			exp.Body.Statements[1])
+	}
+}
+
+func TestForExpression(t *testing.T) {
+	input := `for (x in [1, 2]) { continue; }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.ForExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.ForExpression. got=%T",
+			stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Variable, "x") {
+		return
+	}
+	if exp.Iterable.String() != "[1, 2]" {
+		t.Errorf("exp.Iterable is not %q. got=%q", "[1, 2]", exp.Iterable.String())
+	}
+	if len(exp.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statements. got=%d\n", len(exp.Body.Statements))
+	}
+	if _, ok := exp.Body.Statements[0].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[0] is not ast.ContinueStatement. got=%T",
+			exp.Body.Statements[0])
+	}
+	if program.String() != "for(x in [1, 2]) continue;" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input         string
+		catchVariable string // empty if there is no catch block
+		hasFinally    bool
+		expected      string
+	}{
+		{`try { f() } catch (e) { e }`, "e", false, "try f() catch(e) e"},
+		{`try { f() } finally { g() }`, "", true, "try f() finally g()"},
+		{`try { f() } catch (err) { 1 } finally { g() }`, "err", true, "try f() catch(err) 1 finally g()"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Body does not contain %d statements. got=%d\n",
+				1, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.TryExpression. got=%T",
+				stmt.Expression)
+		}
+		if tt.catchVariable == "" {
+			if exp.Catch != nil || exp.CatchVariable != nil {
+				t.Errorf("expected no catch block, got=%s", exp.Catch)
+			}
+		} else if exp.Catch == nil || !testIdentifier(t, exp.CatchVariable, tt.catchVariable) {
+			t.Errorf("wrong catch block for %q", tt.input)
+		}
+		if (exp.Finally != nil) != tt.hasFinally {
+			t.Errorf("wrong finally block for %q. got=%v", tt.input, exp.Finally)
+		}
+		if program.String() != tt.expected {
+			t.Errorf("program.String() wrong. expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw "bad input"; 1`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Body does not contain %d statements. got=%d\n",
+			2, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ThrowStatement. got=%T",
+			program.Statements[0])
+	}
+	str, ok := stmt.Value.(*ast.StringLiteral)
+	if !ok || str.Value != "bad input" {
+		t.Fatalf("stmt.Value is not the string %q. got=%s", "bad input", stmt.Value)
+	}
+	if program.String() != `throw bad input;1` {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -410,6 +775,7 @@ func TestFunctionParameterParsing(t *testing.T) {
 		{input: "fn() {};", expectedParams: []string{}},
 		{input: "fn(x) {};", expectedParams: []string{"x"}},
 		{input: "fn(x, y, z) {};", expectedParams: []string{"x", "y", "z"}},
+		{input: "fn(x, ...y) {};", expectedParams: []string{"x"}},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -428,6 +794,54 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestFunctionDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		defaults []string
+		rest     string
+		expected string
+	}{
+		{"fn(a, b = 10) {}", []string{"", "10"}, "", "fn(a, b = 10) "},
+		{"fn(a = 1, b = a * 2) {}", []string{"1", "(a * 2)"}, "", "fn(a = 1, b = (a * 2)) "},
+		{"fn(a, ...rest) {}", nil, "rest", "fn(a, ...rest) "},
+		{"fn(...args) {}", nil, "args", "fn(...args) "},
+		{"fn(a, b = [], ...c) {}", []string{"", "[]"}, "c", "fn(a, b = [], ...c) "},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function := stmt.Expression.(*ast.FunctionLiteral)
+
+		for i := range function.Parameters {
+			expected := ""
+			if i < len(tt.defaults) {
+				expected = tt.defaults[i]
+			}
+			got := ""
+			if def := function.Default(i); def != nil {
+				got = def.String()
+			}
+			if got != expected {
+				t.Errorf("%q: wrong default value for parameter %d. want=%q, got=%q",
+					tt.input, i, expected, got)
+			}
+		}
+		rest := ""
+		if function.Rest != nil {
+			rest = function.Rest.Value
+		}
+		if rest != tt.rest {
+			t.Errorf("%q: wrong rest parameter. want=%q, got=%q", tt.input, tt.rest, rest)
+		}
+		if function.String() != tt.expected {
+			t.Errorf("%q: wrong String(). want=%q, got=%q", tt.input, tt.expected, function.String())
+		}
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -520,6 +934,36 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingDotExpressions(t *testing.T) {
+	input := "math.sqrt(2)"
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	call, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.CallExpression. got=%T", stmt.Expression)
+	}
+	indexExp, ok := call.Function.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("function not *ast.IndexExpression. got=%T", call.Function)
+	}
+	if !testIdentifier(t, indexExp.Left, "math") {
+		return
+	}
+	str, ok := indexExp.Index.(*ast.StringLiteral)
+	if !ok || str.Value != "sqrt" {
+		t.Errorf("index is not the string literal \"sqrt\". got=%T(%s)", indexExp.Index, indexExp.Index)
+	}
+
+	p = New(lexer.New("math.(1)"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for a dot not followed by a name")
+	}
+}
+
 func TestParsingHashLiteralsStringKeys(t *testing.T) {
 	input := `{"one": 1, "two": 2, "three": 3}`
 	l := lexer.New(input)
@@ -717,6 +1161,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"-math.abs(x) * a.b[0]",
+			"((-(math[abs])(x)) * ((a[b])[0]))",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || !c && d < e",
+			"((a && b) || ((!c) && (d < e)))",
+		},
+		{
+			"a || b || c",
+			"((a || b) || c)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,206 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juandspy/monkey-lang/ast"
+	"github.com/juandspy/monkey-lang/lexer"
+	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/parser"
+	"github.com/juandspy/monkey-lang/token"
+)
+
+// commands are the commands of the REPL, which start with a colon
+var commands = []struct {
+	name string
+	args string
+	help string
+}{
+	{"help", "", "show this help"},
+	{"env", "", "list the bindings of the session"},
+	{"tokens", "<src>", "show the tokens of the source code"},
+	{"ast", "<src>", "show the syntax tree of the source code"},
+	{"type", "<expr>", "run the expression in the session and show the type of its value"},
+	{"load", "<file>", "run the file in the session"},
+	{"reset", "", "remove all the bindings of the session"},
+	{"time", "<expr>", "run the expression and show how long it took"},
+	{"quit", "", "exit the REPL"},
+}
+
+// command runs the command in the line, like `:ast 1 + 2`, and returns true
+// if the REPL must exit
+func (s *session) command(line string) bool {
+	name, args := line[1:], ""
+	if i := strings.IndexAny(name, " \t"); i >= 0 {
+		name, args = name[:i], strings.TrimSpace(name[i+1:])
+	}
+	for _, cmd := range commands {
+		if cmd.name == name && cmd.args != "" && args == "" {
+			fmt.Fprintf(s.out, "usage: :%s %s\n", cmd.name, cmd.args)
+			return false
+		}
+	}
+
+	switch name {
+	case "help":
+		s.help()
+	case "env":
+		s.env()
+	case "tokens":
+		s.tokens(args)
+	case "ast":
+		s.ast(args)
+	case "type":
+		s.typeOf(args)
+	case "load":
+		s.load(args)
+	case "reset":
+		s.runner = newRunner(s.engine, nil, s.streams)
+	case "time":
+		s.time(args)
+	case "quit":
+		return true
+	default:
+		fmt.Fprintf(s.out, "unknown command :%s, see :help\n", name)
+	}
+	return false
+}
+
+func (s *session) help() {
+	for _, cmd := range commands {
+		usage := ":" + cmd.name
+		if cmd.args != "" {
+			usage += " " + cmd.args
+		}
+		fmt.Fprintf(s.out, "  %-15s %s\n", usage, cmd.help)
+	}
+}
+
+// env writes the bindings sorted by name
+func (s *session) env() {
+	bindings := s.runner.bindings()
+	names := make([]string, 0, len(bindings))
+	for name := range bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.out, "%s = %s\n", name, bindings[name].Inspect())
+	}
+}
+
+// tokens writes the tokens of the source code, one per line
+func (s *session) tokens(src string) {
+	l := lexer.New(src)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		fmt.Fprintf(s.out, "%s\t%s\t%q\n", tok.Pos, tok.Type, tok.Literal)
+	}
+}
+
+// ast writes the syntax tree of the source code
+func (s *session) ast(src string) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return
+	}
+	writeTree(s.out, program)
+}
+
+// typeOf writes the type of the value of the expression, or the error it
+// ended with. The expression runs in the session like any other line, so its
+// effects, like assignments, are kept.
+func (s *session) typeOf(src string) {
+	evaluated, ok := s.eval(lexer.New(src))
+	if !ok || evaluated == nil {
+		return
+	}
+	if err, isErr := evaluated.(*object.Error); isErr {
+		fmt.Fprintln(s.out, err.Inspect())
+		return
+	}
+	fmt.Fprintln(s.out, evaluated.Type())
+}
+
+func (s *session) load(filename string) {
+	input, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(s.out, err)
+		return
+	}
+	s.runInput(lexer.NewFile(filename, string(input)))
+}
+
+func (s *session) time(src string) {
+	start := time.Now()
+	s.runInput(lexer.New(src))
+	fmt.Fprintf(s.out, "time: %s\n", time.Since(start))
+}
+
+var nodeType = reflect.TypeOf((*ast.Node)(nil)).Elem()
+
+// writeTree writes the node and its children, one per line and indented by
+// their depth. The fields that are not nodes are written below their node, and
+// the tokens are left out.
+func writeTree(out io.Writer, node ast.Node) {
+	fmt.Fprintln(out, nodeName(node))
+	writeFields(out, node, "  ")
+}
+
+func writeFields(out io.Writer, node ast.Node, indent string) {
+	value := reflect.ValueOf(node).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field, name := value.Field(i), value.Type().Field(i).Name
+		if field.Type() == reflect.TypeOf(token.Token{}) || field.IsZero() {
+			continue
+		}
+		switch {
+		case field.Type().Implements(nodeType):
+			writeChild(out, name, field, indent)
+		case field.Kind() == reflect.Slice:
+			for j := 0; j < field.Len(); j++ {
+				writeChild(out, fmt.Sprintf("%s[%d]", name, j), field.Index(j), indent)
+			}
+		case field.Kind() == reflect.Map:
+			// the pairs of the hashes, in the order of the source code
+			keys := field.MapKeys()
+			sort.Slice(keys, func(a, b int) bool {
+				return keys[a].Interface().(ast.Node).Pos().Offset < keys[b].Interface().(ast.Node).Pos().Offset
+			})
+			for j, key := range keys {
+				writeChild(out, fmt.Sprintf("%s[%d].Key", name, j), key, indent)
+				writeChild(out, fmt.Sprintf("%s[%d].Value", name, j), field.MapIndex(key), indent)
+			}
+		case field.Kind() == reflect.String:
+			fmt.Fprintf(out, "%s%s: %q\n", indent, name, field.String())
+		default:
+			fmt.Fprintf(out, "%s%s: %v\n", indent, name, field.Interface())
+		}
+	}
+}
+
+func writeChild(out io.Writer, name string, field reflect.Value, indent string) {
+	if field.IsNil() {
+		return
+	}
+	child := field.Interface().(ast.Node)
+	fmt.Fprintf(out, "%s%s: %s\n", indent, name, nodeName(child))
+	writeFields(out, child, indent+"  ")
+}
+
+// nodeName returns the type of the node and its position, like
+// `LetStatement 1:1`
+func nodeName(node ast.Node) string {
+	name := reflect.TypeOf(node).Elem().Name()
+	if pos := node.Pos(); pos.IsValid() {
+		name += " " + pos.String()
+	}
+	return name
+}
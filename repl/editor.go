@@ -0,0 +1,507 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/token"
+)
+
+// HISTORY_FILE is the file in the home directory where the lines typed in the
+// REPL are saved
+const HISTORY_FILE = ".monkey_history"
+
+// HISTORY_SIZE is the number of lines kept in the history
+const HISTORY_SIZE = 1000
+
+// errInterrupted is returned by the line editor when Ctrl-C is pressed, to
+// discard the input being typed
+var errInterrupted = errors.New("interrupted")
+
+// Keys read by the line editor. The control keys are their ASCII codes, and
+// the keys sent as escape sequences are negative so that they don't clash
+// with any character.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlG     = 7
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlR     = 18
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+const (
+	keyUp = -(iota + 1)
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDelete
+	keyUnknown
+)
+
+// lineEditor reads lines from a terminal in raw mode, with the usual editing
+// keys of a shell: arrows, Home and End, Ctrl-A, Ctrl-E, Ctrl-K, Ctrl-U and
+// Ctrl-W to move and delete, Up and Down to browse the history, Ctrl-R to
+// search it and Tab to complete the word before the cursor.
+type lineEditor struct {
+	in  io.Reader
+	out io.Writer
+
+	// width returns the number of columns of the terminal. The lines longer
+	// than the terminal scroll horizontally.
+	width func() int
+	// complete returns the words that start with the given one
+	complete func(word string) []string
+	history  *history
+
+	pending []byte // bytes read but not used yet, e.g. pasted text
+}
+
+// readLine shows the prompt and returns the line typed by the user, without
+// the end of line. It returns io.EOF if Ctrl-D is pressed on an empty line,
+// and errInterrupted if Ctrl-C is pressed.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	var line []rune
+	pos := 0                      // position of the cursor in the line
+	index := len(e.history.lines) // entry of the history being shown
+	draft := ""                   // the line typed before browsing the history
+	var key rune
+	var err error
+	redo := false // the key ended a search, and must be handled too
+
+	for {
+		e.refresh(prompt, line, pos)
+		if !redo {
+			if key, err = e.readKey(); err != nil {
+				return "", err
+			}
+		}
+		redo = false
+
+		switch key {
+		case keyEnter:
+			io.WriteString(e.out, "\r\n")
+			e.history.add(string(line))
+			return string(line), nil
+		case keyCtrlC:
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(line) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case keyDelete:
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case keyBackspace, keyCtrlH:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case keyLeft, keyCtrlB:
+			if pos > 0 {
+				pos--
+			}
+		case keyRight, keyCtrlF:
+			if pos < len(line) {
+				pos++
+			}
+		case keyHome, keyCtrlA:
+			pos = 0
+		case keyEnd, keyCtrlE:
+			pos = len(line)
+		case keyCtrlK:
+			line = line[:pos]
+		case keyCtrlU:
+			line = append([]rune{}, line[pos:]...)
+			pos = 0
+		case keyCtrlW:
+			start := pos
+			for start > 0 && line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && line[start-1] != ' ' {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+		case keyCtrlL:
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case keyUp, keyCtrlP, keyDown, keyCtrlN:
+			next := index - 1
+			if key == keyDown || key == keyCtrlN {
+				next = index + 1
+			}
+			if next < 0 || next > len(e.history.lines) {
+				break
+			}
+			if index == len(e.history.lines) {
+				draft = string(line)
+			}
+			index = next
+			if index == len(e.history.lines) {
+				line = []rune(draft)
+			} else {
+				line = []rune(e.history.lines[index])
+			}
+			pos = len(line)
+		case keyTab:
+			line, pos = e.completeWord(prompt, line, pos)
+		case keyCtrlR:
+			line, key, err = e.search(line)
+			if err != nil {
+				return "", err
+			}
+			pos = len(line)
+			redo = key != 0
+		default:
+			if key >= ' ' {
+				line = append(line[:pos], append([]rune{key}, line[pos:]...)...)
+				pos++
+			}
+		}
+	}
+}
+
+// refresh writes the prompt and the line, leaving the cursor at pos
+func (e *lineEditor) refresh(prompt string, line []rune, pos int) {
+	promptLen := utf8.RuneCountInString(prompt)
+	available := e.width() - promptLen - 1
+	if available < 1 {
+		available = 1
+	}
+	// the part of the line around the cursor that fits in the terminal
+	start := 0
+	if pos > available {
+		start = pos - available
+	}
+	end := len(line)
+	if end > start+available {
+		end = start + available
+	}
+
+	var out strings.Builder
+	out.WriteString("\r" + prompt + string(line[start:end]) + "\x1b[K\r")
+	if column := promptLen + pos - start; column > 0 {
+		fmt.Fprintf(&out, "\x1b[%dC", column)
+	}
+	io.WriteString(e.out, out.String())
+}
+
+// search lets the user search the history backwards while typing, like Ctrl-R
+// in a shell. It returns the line found, or the given one if the search is
+// canceled, and the key that ended the search if it must be handled too.
+func (e *lineEditor) search(line []rune) ([]rune, rune, error) {
+	var query []rune
+	lines := e.history.lines
+	index := len(lines) // entry of the history matching the query
+	found := line
+
+	// find looks for the last entry up to the given one that contains the query
+	find := func(from int) {
+		for i := from; i >= 0; i-- {
+			if strings.Contains(lines[i], string(query)) {
+				index, found = i, []rune(lines[i])
+				return
+			}
+		}
+		index = -1
+	}
+
+	for {
+		prompt := "(reverse-i-search)`" + string(query) + "': "
+		if index < 0 {
+			prompt = "(failed " + prompt[1:]
+		}
+		e.refresh(prompt, found, len(found))
+
+		key, err := e.readKey()
+		if err != nil {
+			return nil, 0, err
+		}
+		switch key {
+		case keyCtrlR:
+			if index > 0 {
+				find(index - 1)
+			}
+		case keyBackspace, keyCtrlH:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(lines) - 1)
+			}
+		case keyCtrlG, keyCtrlC:
+			return line, 0, nil
+		default:
+			if key < ' ' {
+				return found, key, nil
+			}
+			query = append(query, key)
+			// the current entry may contain the longer query too
+			from := index
+			if from < 0 || from == len(lines) {
+				from = len(lines) - 1
+			}
+			find(from)
+		}
+	}
+}
+
+// completeWord completes the word before the cursor with the longest prefix
+// of its completions. If there isn't a longer one, the completions are listed
+// below the line.
+func (e *lineEditor) completeWord(prompt string, line []rune, pos int) ([]rune, int) {
+	start := pos
+	for start > 0 && isWordRune(line[start-1]) {
+		start--
+	}
+	word := string(line[start:pos])
+	candidates := e.complete(word)
+	if len(candidates) == 0 {
+		io.WriteString(e.out, "\a")
+		return line, pos
+	}
+
+	prefix := []rune(candidates[0])
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, string(prefix)) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if rest := prefix[pos-start:]; len(rest) > 0 {
+		line = append(line[:pos], append(rest, line[pos:]...)...)
+		return line, pos + len(rest)
+	}
+	if len(candidates) > 1 {
+		e.refresh(prompt, line, len(line))
+		io.WriteString(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+	}
+	return line, pos
+}
+
+// isWordRune returns true if the rune can be part of an identifier, or of a
+// function of a namespace like `math.sqrt`
+func isWordRune(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// readKey reads a character or a key sent as an escape sequence
+func (e *lineEditor) readKey() (rune, error) {
+	b, err := e.readByte()
+	if err != nil {
+		return 0, err
+	}
+	if b == keyEscape {
+		return e.readEscape()
+	}
+	if b < utf8.RuneSelf {
+		return rune(b), nil
+	}
+
+	// the rest of a multi-byte character
+	bytes := []byte{b}
+	for !utf8.FullRune(bytes) {
+		b, err := e.readByte()
+		if err != nil {
+			return 0, err
+		}
+		bytes = append(bytes, b)
+	}
+	r, _ := utf8.DecodeRune(bytes)
+	return r, nil
+}
+
+// readEscape reads the escape sequence of a key, like `ESC [ A` for Up or
+// `ESC [ 3 ~` for Delete
+func (e *lineEditor) readEscape() (rune, error) {
+	b, err := e.readByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return keyUnknown, err
+	}
+	var params []byte
+	for {
+		b, err = e.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if b < '0' || b > ';' { // not a digit, colon or semicolon
+			break
+		}
+		params = append(params, b)
+	}
+
+	switch b {
+	case 'A':
+		return keyUp, nil
+	case 'B':
+		return keyDown, nil
+	case 'C':
+		return keyRight, nil
+	case 'D':
+		return keyLeft, nil
+	case 'H':
+		return keyHome, nil
+	case 'F':
+		return keyEnd, nil
+	case '~':
+		switch string(params) {
+		case "1", "7":
+			return keyHome, nil
+		case "4", "8":
+			return keyEnd, nil
+		case "3":
+			return keyDelete, nil
+		}
+	}
+	return keyUnknown, nil
+}
+
+func (e *lineEditor) readByte() (byte, error) {
+	if len(e.pending) == 0 {
+		buf := make([]byte, 256)
+		n, err := e.in.Read(buf)
+		if n == 0 {
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		e.pending = buf[:n]
+	}
+	b := e.pending[0]
+	e.pending = e.pending[1:]
+	return b, nil
+}
+
+// history is the list of the lines typed in the REPL. It's saved in a file to
+// keep it between sessions.
+type history struct {
+	lines []string
+	file  string // the file it's saved in, or "" if it's not saved
+}
+
+// loadHistory reads the history saved in the file, if any. The file is
+// truncated to the last HISTORY_SIZE lines.
+func loadHistory(file string) *history {
+	h := &history{file: file}
+	f, err := os.Open(file)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.lines = append(h.lines, scanner.Text())
+	}
+	if len(h.lines) > HISTORY_SIZE {
+		h.lines = h.lines[len(h.lines)-HISTORY_SIZE:]
+		os.WriteFile(file, []byte(strings.Join(h.lines, "\n")+"\n"), 0o600)
+	}
+	return h
+}
+
+// add appends the line to the history and to its file, unless it's empty or
+// the same as the previous one. Errors writing the file are ignored, the
+// history is just not saved.
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" ||
+		(len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if h.file == "" {
+		return
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// terminalReader returns a function that reads the lines typed in the
+// terminal with a line editor. The terminal is in raw mode only while a line
+// is read, so that the programs read their input as usual.
+func (s *session) terminalReader(f *os.File) func(prompt string) (string, error) {
+	file := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		file = filepath.Join(home, HISTORY_FILE)
+	}
+	editor := &lineEditor{
+		in:       f,
+		out:      s.out,
+		width:    func() int { return terminalWidth(f) },
+		complete: s.complete,
+		history:  loadHistory(file),
+	}
+	return func(prompt string) (string, error) {
+		state, err := makeRaw(f)
+		if err != nil {
+			io.WriteString(s.out, prompt)
+			return s.streams.ReadLine()
+		}
+		defer restore(f, state)
+		return editor.readLine(prompt)
+	}
+}
+
+// complete returns the keywords, builtins and bindings that start with the
+// given word, sorted. A word with a dot, like `math.s`, is completed with the
+// functions of the namespace.
+func (s *session) complete(word string) []string {
+	var names []string
+	if i := strings.LastIndex(word, "."); i >= 0 {
+		namespace, _ := s.runner.registry().Lookup(word[:i])
+		if hash, ok := namespace.(*object.Hash); ok {
+			for _, pair := range hash.Pairs {
+				if key, ok := pair.Key.(*object.String); ok {
+					names = append(names, word[:i+1]+key.Value)
+				}
+			}
+		}
+	} else {
+		names = append(token.Keywords(), s.runner.registry().Names()...)
+		for name := range s.runner.bindings() {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	var candidates []string
+	for i, name := range names {
+		if name != "" && strings.HasPrefix(name, word) && (i == 0 || name != names[i-1]) {
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates
+}
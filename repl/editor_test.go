@@ -0,0 +1,136 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/juandspy/monkey-lang/lexer"
+)
+
+func newTestEditor(keys string, lines ...string) *lineEditor {
+	words := []string{"len", "let", "math.sqrt"}
+	return &lineEditor{
+		in:    strings.NewReader(keys),
+		out:   &bytes.Buffer{},
+		width: func() int { return 80 },
+		complete: func(word string) []string {
+			var candidates []string
+			for _, w := range words {
+				if strings.HasPrefix(w, word) {
+					candidates = append(candidates, w)
+				}
+			}
+			return candidates
+		},
+		history: &history{lines: lines},
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	history := []string{"let a = 1;", "puts(a)", "a + 2"}
+	tests := []struct {
+		keys     string
+		expected string
+	}{
+		{"1 + 2\r", "1 + 2"},
+		{"héllo\r", "héllo"},
+		{"12\x1b[D\x1b[D0\r", "012"},
+		{"12\x7f3\r", "13"},
+		{"abc\x01x\x05y\r", "xabcy"},
+		{"abc\x1b[H\x1b[3~\x1b[F!\r", "bc!"},
+		{"abc\x02\x02\x0b\r", "a"},
+		{"abc\x02\x15\r", "c"},
+		{"let x = 1\x17\x17\r", "let x "},
+		{"\x1b[A\r", "a + 2"},
+		{"\x1b[A\x1b[A\x1b[A\x1b[A\r", "let a = 1;"},
+		{"draft\x1b[A\x1b[A\x1b[B\x1b[B\r", "draft"},
+		{"\x12a\r", "a + 2"},
+		{"\x12a\x12\x12\r", "let a = 1;"},
+		{"\x12puts\x1b[D\x1b[D\x7f\r", "putsa)"},
+		{"x\x12nope\x07\r", "x"},
+		{"le\t\r", "le"},
+		{"l\t\tn(1)\r", "len(1)"},
+		{"math.s\t(4)\r", "math.sqrt(4)"},
+	}
+	for _, tt := range tests {
+		editor := newTestEditor(tt.keys, history...)
+		line, err := editor.readLine(PROMPT)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.keys, err)
+			continue
+		}
+		if line != tt.expected {
+			t.Errorf("wrong line for %q. expected=%q, got=%q", tt.keys, tt.expected, line)
+		}
+	}
+}
+
+func TestLineEditorEndOfInput(t *testing.T) {
+	editor := newTestEditor("abc\x03\x04")
+	if _, err := editor.readLine(PROMPT); err != errInterrupted {
+		t.Errorf("Ctrl-C must interrupt the line, got=%v", err)
+	}
+	if _, err := editor.readLine(PROMPT); err != io.EOF {
+		t.Errorf("Ctrl-D on an empty line must end the input, got=%v", err)
+	}
+	if len(editor.history.lines) != 0 {
+		t.Errorf("the interrupted lines must not be saved, got=%q", editor.history.lines)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), HISTORY_FILE)
+	h := loadHistory(file)
+	for _, line := range []string{"1", "2", "2", " ", "", "1"} {
+		h.add(line)
+	}
+	expected := []string{"1", "2", "1"}
+	if !reflect.DeepEqual(h.lines, expected) {
+		t.Errorf("wrong history. expected=%q, got=%q", expected, h.lines)
+	}
+	if loaded := loadHistory(file); !reflect.DeepEqual(loaded.lines, expected) {
+		t.Errorf("wrong saved history. expected=%q, got=%q", expected, loaded.lines)
+	}
+
+	lines := make([]string, HISTORY_SIZE+10)
+	for i := range lines {
+		lines[i] = strings.Repeat("x", i+1)
+	}
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := loadHistory(file); !reflect.DeepEqual(loaded.lines, lines[10:]) {
+		t.Errorf("the history must keep the last %d lines, got %d", HISTORY_SIZE, len(loaded.lines))
+	}
+	content, _ := os.ReadFile(file)
+	if saved := strings.Count(string(content), "\n"); saved != HISTORY_SIZE {
+		t.Errorf("the history file must be truncated to %d lines, got %d", HISTORY_SIZE, saved)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s := &session{out: &bytes.Buffer{}, runner: newRunner(EngineEval, nil, nil)}
+	s.runInput(lexer.New("let length = 1; let letters = 2;"))
+
+	tests := []struct {
+		word     string
+		expected []string
+	}{
+		{"le", []string{"len", "length", "let", "letters"}},
+		{"wh", []string{"while"}},
+		{"math.s", []string{"math.sqrt"}},
+		{"strings.", []string{"strings.join", "strings.split"}},
+		{"nope.", nil},
+		{"zzz", nil},
+	}
+	for _, tt := range tests {
+		if got := s.complete(tt.word); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong completions for %q. expected=%q, got=%q", tt.word, tt.expected, got)
+		}
+	}
+}
@@ -1,50 +1,269 @@
 package repl
 
 import (
-	"bufio"
-	"fmt"
 	"io"
+	"os"
+	"strings"
 
+	"github.com/juandspy/monkey-lang/ast"
+	"github.com/juandspy/monkey-lang/compiler"
 	"github.com/juandspy/monkey-lang/evaluator"
 	"github.com/juandspy/monkey-lang/lexer"
 	"github.com/juandspy/monkey-lang/object"
 	"github.com/juandspy/monkey-lang/parser"
+	"github.com/juandspy/monkey-lang/token"
+	"github.com/juandspy/monkey-lang/vm"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+// CONTINUATION_PROMPT is shown while the input is incomplete, like a function
+// whose body is not closed yet
+const CONTINUATION_PROMPT = ".. "
+
+// Engines that can run the Monkey code
+const (
+	EngineEval = "eval" // tree-walking evaluator
+	EngineVM   = "vm"   // bytecode compiler and virtual machine
+)
+
+// Start runs the REPL using the given engine, keeping the bindings between
+// lines. The programs read from in and write to out too, with `input` or `puts`.
+// The lines starting with a colon are commands of the REPL, see :help.
+//
+// If in is a terminal, the lines are read with a line editor that keeps the
+// history in HISTORY_FILE and completes the names with Tab.
+func Start(in io.Reader, out io.Writer, engine string) {
+	// the lines are read from the streams of the programs, so that the
+	// input they read is not taken by the REPL
+	streams := object.NewStreams(in, out, out)
+	s := &session{
+		out:     out,
+		engine:  engine,
+		streams: streams,
+		runner:  newRunner(engine, nil, streams),
+	}
+	readLine := func(prompt string) (string, error) {
+		io.WriteString(out, prompt)
+		return streams.ReadLine()
+	}
+	if f, ok := in.(*os.File); ok && isTerminal(f) {
+		readLine = s.terminalReader(f)
+	}
 
+	var lines []string // lines of an incomplete input
 	for {
-		fmt.Print(PROMPT)
+		prompt := PROMPT
+		if len(lines) > 0 {
+			prompt = CONTINUATION_PROMPT
+		}
 		// read the user input
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := readLine(prompt)
+		if err == errInterrupted {
+			lines = nil
+			continue
+		}
+		if err != nil {
+			if len(lines) > 0 {
+				s.runInput(lexer.New(strings.Join(lines, "\n")))
+			}
 			return
 		}
-		line := scanner.Text()
 
-		l := lexer.New(line) // start a lexer with the user input
-		p := parser.New(l)
+		if len(lines) == 0 && strings.HasPrefix(line, ":") {
+			if quit := s.command(line); quit {
+				return
+			}
+			continue
+		}
 
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+		lines = append(lines, line)
+		input := strings.Join(lines, "\n")
+		// an empty line runs an incomplete input anyway, to show its errors
+		if line != "" && isIncomplete(input) {
 			continue
 		}
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+		lines = nil
+		s.runInput(lexer.New(input))
+	}
+}
+
+// session is the state of a running REPL
+type session struct {
+	out     io.Writer
+	engine  string
+	streams *object.Streams
+	runner  runner
+}
+
+// runInput parses and runs the input, writing its result or errors to out
+func (s *session) runInput(l *lexer.Lexer) {
+	evaluated, ok := s.eval(l)
+	if ok && evaluated != nil {
+		io.WriteString(s.out, evaluated.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+}
+
+// eval parses and runs the input. The parser errors and the traceback of the
+// runtime errors are written to out, and the result is false if the input
+// couldn't be parsed.
+func (s *session) eval(l *lexer.Lexer) (object.Object, bool) {
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Errors())
+		return nil, false
+	}
+	evaluated := s.runner.run(program)
+	if err, ok := evaluated.(*object.Error); ok {
+		io.WriteString(s.out, err.StackTrace())
+	}
+	return evaluated, true
+}
+
+// isIncomplete returns true if the input ends before its last statement does,
+// because a parenthesis, bracket, brace, string or comment is not closed or an
+// expression is not finished, so that the next lines can complete it
+func isIncomplete(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACKET, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACKET, token.RBRACE:
+			depth--
+		}
+	}
+	if depth > 0 {
+		return true
+	}
+
+	p := parser.New(lexer.New(input))
+	p.ParseProgram()
+	errors := p.Errors()
+	if len(errors) == 0 {
+		return false
+	}
+	last := errors[len(errors)-1]
+	// the unterminated strings and comments reach the end of the input
+	return last.Got == token.EOF || strings.HasPrefix(last.Message, "unterminated")
+}
+
+// runner runs programs with one of the engines. The state (bindings,
+// constants...) is kept between programs.
+type runner interface {
+	run(program *ast.Program) object.Object
+	// bindings returns the values bound by the programs at the top level
+	bindings() map[string]object.Object
+	// registry returns the builtins available to the programs
+	registry() *object.Registry
+}
+
+// newRunner returns a runner using the given engine. The given globals are
+// defined before running the first program, and the builtins use the streams.
+func newRunner(engine string, globals map[string]object.Object, streams *object.Streams) runner {
+	if engine == EngineVM {
+		return newVMRunner(globals, streams)
+	}
+	env := object.NewEnvironment()
+	env.Execution().SetStreams(streams)
+	for name, value := range globals {
+		env.Set(name, value)
+	}
+	return &evalRunner{env: env}
+}
+
+type evalRunner struct {
+	env *object.Environment
+}
+
+func (r *evalRunner) run(program *ast.Program) object.Object {
+	return evaluator.Eval(program, r.env)
+}
+
+func (r *evalRunner) bindings() map[string]object.Object {
+	bindings := map[string]object.Object{}
+	for _, name := range r.env.Names() {
+		bindings[name], _ = r.env.Get(name)
+	}
+	return bindings
+}
+
+func (r *evalRunner) registry() *object.Registry {
+	return r.env.Builtins()
+}
+
+type vmRunner struct {
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+	builtins    *object.Registry
+	streams     *object.Streams
+}
+
+func newVMRunner(definitions map[string]object.Object, streams *object.Streams) *vmRunner {
+	r := &vmRunner{
+		symbolTable: compiler.NewSymbolTable(),
+		constants:   []object.Object{},
+		globals:     make([]object.Object, vm.GlobalsSize),
+		builtins:    object.NewRegistry(),
+		streams:     streams,
+	}
+	compiler.DefineBuiltins(r.symbolTable, r.builtins)
+	for name, value := range definitions {
+		symbol := r.symbolTable.Define(name)
+		r.globals[symbol.Index] = value
+	}
+	return r
+}
+
+func (r *vmRunner) run(program *ast.Program) object.Object {
+	comp := compiler.NewWithState(r.symbolTable, r.constants, r.builtins)
+	if err := comp.Compile(program); err != nil {
+		return toErrorObject(err)
+	}
+	bytecode := comp.Bytecode()
+	r.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobalsStore(bytecode, r.globals)
+	machine.SetStreams(r.streams)
+	if err := machine.Run(); err != nil {
+		return toErrorObject(err)
+	}
+	return machine.LastPoppedStackElem()
+}
+
+func (r *vmRunner) bindings() map[string]object.Object {
+	bindings := map[string]object.Object{}
+	for _, symbol := range r.symbolTable.Symbols() {
+		// a symbol is declared before its value is computed, which may fail.
+		// Its global stays nil, which the VM reports as not found.
+		if symbol.Scope == compiler.GlobalScope && r.globals[symbol.Index] != nil {
+			bindings[symbol.Name] = r.globals[symbol.Index]
 		}
 	}
+	return bindings
+}
+
+func (r *vmRunner) registry() *object.Registry {
+	return r.builtins
+}
+
+// toErrorObject converts the errors returned by the compiler and the VM into
+// *object.Error so that they are printed like the evaluator ones
+func toErrorObject(err error) *object.Error {
+	if objErr, ok := err.(*object.Error); ok {
+		return objErr
+	}
+	return &object.Error{Message: err.Error()}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
+func printParserErrors(out io.Writer, errors []*parser.Error) {
 	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, err := range errors {
+		io.WriteString(out, "\t"+err.Error()+"\n")
 	}
 }
@@ -0,0 +1,163 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	// the line read by `input` is not run by the REPL
+	input := "let x = input();\n1 + 1\nx\n"
+	for _, engine := range []string{EngineEval, EngineVM} {
+		var out bytes.Buffer
+		Start(strings.NewReader(input), &out, engine)
+		expected := PROMPT + PROMPT + "1 + 1\n" + PROMPT
+		if out.String() != expected {
+			t.Errorf("[%s] wrong output. expected=%q, got=%q", engine, expected, out.String())
+		}
+	}
+}
+
+// TestStartFailedLet checks that a let whose value fails doesn't define the
+// name in the session
+func TestStartFailedLet(t *testing.T) {
+	input := "let x = 1 / 0;\nx + 1\n"
+	for _, engine := range []string{EngineEval, EngineVM} {
+		var out bytes.Buffer
+		Start(strings.NewReader(input), &out, engine)
+		expected := PROMPT + "ERROR: 1:11: division by zero\n" + PROMPT + "ERROR: 1:1: identifier not found: x\n" + PROMPT
+		if out.String() != expected {
+			t.Errorf("[%s] wrong output. expected=%q, got=%q", engine, expected, out.String())
+		}
+	}
+}
+
+// TestStartFunction checks that both engines print the source of the functions
+func TestStartFunction(t *testing.T) {
+	input := "fn(x) { x }\nputs(fn(a, b = 1, ...c) { a + b })\n"
+	for _, engine := range []string{EngineEval, EngineVM} {
+		var out bytes.Buffer
+		Start(strings.NewReader(input), &out, engine)
+		expected := PROMPT + "fn(x) {\nx\n}\n" + PROMPT + "fn(a, b = 1, ...c) {\n(a + b)\n}\nnull\n" + PROMPT
+		if out.String() != expected {
+			t.Errorf("[%s] wrong output. expected=%q, got=%q", engine, expected, out.String())
+		}
+	}
+}
+
+func TestStartMultiLine(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"let add = fn(a, b) {\n  a + b\n};\nadd(1, 2)\n",
+			PROMPT + CONTINUATION_PROMPT + CONTINUATION_PROMPT + PROMPT + "3\n" + PROMPT,
+		},
+		{
+			"let s = `a\nb`; len(s)\n",
+			PROMPT + CONTINUATION_PROMPT + "3\n" + PROMPT,
+		},
+		{
+			"[1,\n2] /* a\ncomment */\n",
+			PROMPT + CONTINUATION_PROMPT + CONTINUATION_PROMPT + "[1, 2]\n" + PROMPT,
+		},
+		{
+			"try { 1 }\ncatch (e) { 2 }\n",
+			PROMPT + CONTINUATION_PROMPT + "1\n" + PROMPT,
+		},
+		{
+			// an empty line shows the errors of the incomplete input
+			"let x = (1 +\n\nx\n",
+			PROMPT + CONTINUATION_PROMPT + " parser errors:\n\t2:1: no prefix parse function for EOF found\n" +
+				PROMPT + "ERROR: 1:1: identifier not found: x\n" + PROMPT,
+		},
+		{
+			// the incomplete input is run at the end
+			"puts(1,\n",
+			PROMPT + CONTINUATION_PROMPT + " parser errors:\n\t1:8: no prefix parse function for EOF found\n",
+		},
+		{
+			"1 + 2)\n",
+			PROMPT + " parser errors:\n\t1:6: no prefix parse function for ) found\n" + PROMPT,
+		},
+	}
+	for _, engine := range []string{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			var out bytes.Buffer
+			Start(strings.NewReader(tt.input), &out, engine)
+			if out.String() != tt.expected {
+				t.Errorf("[%s] wrong output for %q.\nexpected=%q\ngot=     %q", engine, tt.input, tt.expected, out.String())
+			}
+		}
+	}
+}
+
+func TestStartCommands(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lib.mk")
+	if err := os.WriteFile(file, []byte("let double = fn(x) { x * 2 };\ndouble(2)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{":help\n", "  :help           show this help\n"},
+		{"let b = 2; let a = [1];\n:env\n", "a = [1]\nb = 2\n"},
+		{":reset\n:env\n", ""},
+		{"let a = 1;\n:reset\na\n", "ERROR: 1:1: identifier not found: a\n"},
+		{":tokens let x = `a`;\n", "1:1\tLET\t\"let\"\n1:5\tIDENT\t\"x\"\n1:7\t=\t\"=\"\n1:9\tSTRING\t\"a\"\n1:12\t;\t\";\"\n"},
+		{
+			":ast -x + 1\n",
+			"Program 1:1\n" +
+				"  Statements[0]: ExpressionStatement 1:1\n" +
+				"    Expression: InfixExpression 1:4\n" +
+				"      Left: PrefixExpression 1:1\n" +
+				"        Operator: \"-\"\n" +
+				"        Right: Identifier 1:2\n" +
+				"          Value: \"x\"\n" +
+				"      Operator: \"+\"\n" +
+				"      Right: IntegerLiteral 1:6\n" +
+				"        Value: 1\n",
+		},
+		{":ast {1: true}\n", "Program 1:1\n" +
+			"  Statements[0]: ExpressionStatement 1:1\n" +
+			"    Expression: HashLiteral 1:1\n" +
+			"      Pairs[0].Key: IntegerLiteral 1:2\n" +
+			"        Value: 1\n" +
+			"      Pairs[0].Value: Boolean 1:5\n" +
+			"        Value: true\n"},
+		{":ast 1 +\n", " parser errors:\n\t1:4: no prefix parse function for EOF found\n"},
+		{":type \"a\"\n:type [1][0]\n:type x\n", "STRING\nINTEGER\nERROR: 1:1: identifier not found: x\n"},
+		{":load " + file + "\ndouble(3)\n", "4\n6\n"},
+		{":load missing.mk\n", "open missing.mk: no such file or directory\n"},
+		{":type\n", "usage: :type <expr>\n"},
+		// the expression runs in the session
+		{"let x = 1\n:type fn() { x = 2; x }()\nx\n", "INTEGER\n2\n"},
+		{":what\n", "unknown command :what, see :help\n"},
+		{":quit\n1\n", ""},
+	}
+	for _, engine := range []string{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			var out bytes.Buffer
+			Start(strings.NewReader(tt.input), &out, engine)
+			// the prompts are left out
+			got := strings.ReplaceAll(out.String(), PROMPT, "")
+			if !strings.HasPrefix(got, tt.expected) {
+				t.Errorf("[%s] wrong output for %q.\nexpected=%q\ngot=     %q", engine, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	var out bytes.Buffer
+	Start(strings.NewReader(":time 1 + 1\n"), &out, EngineEval)
+	if got := out.String(); !strings.HasPrefix(got, PROMPT+"2\ntime: ") {
+		t.Errorf("wrong output. got=%q", got)
+	}
+}
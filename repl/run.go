@@ -0,0 +1,41 @@
+package repl
+
+import (
+	"fmt"
+
+	"github.com/juandspy/monkey-lang/lexer"
+	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/parser"
+)
+
+// Run runs a whole program with the given engine. The arguments are available
+// to the program as the `args` array of strings. Errors are written to the
+// stderr of the streams, and the result is false if the program couldn't be
+// parsed or ended with an error.
+func Run(filename, input string, args []string, streams *object.Streams, engine string) bool {
+	errOut := streams.Stderr
+	l := lexer.NewFile(filename, input)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, err := range p.Errors() {
+			fmt.Fprintln(errOut, err.Error())
+		}
+		return false
+	}
+
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	globals := map[string]object.Object{"args": &object.Array{Elements: elements}}
+
+	result := newRunner(engine, globals, streams).run(program)
+	if err, ok := result.(*object.Error); ok {
+		fmt.Fprint(errOut, err.StackTrace())
+		fmt.Fprintln(errOut, err.Inspect())
+		return false
+	}
+	return true
+}
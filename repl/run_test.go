@@ -0,0 +1,75 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/juandspy/monkey-lang/object"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input         string
+		args          []string
+		expectedOk    bool
+		expectedError string
+	}{
+		{"let x = 1 + 2;", nil, true, ""},
+		{"#!/usr/bin/env monkey\nlet x = 1;", nil, true, ""},
+		{"if (len(args) != 2) { 1 + true }", []string{"a", "b"}, true, ""},
+		{"if (len(first(args)) != 3) { 1 + true }", []string{"abc"}, true, ""},
+		{"let x = 1;\nx + true;", nil, false, "ERROR: script.mk:2:3: type mismatch: INTEGER + BOOLEAN\n"},
+		{"let x 1;", nil, false, "script.mk:1:7: expected next token to be =, got INT instead\n"},
+		{
+			"let f = fn(x) {\n  x + true\n};\nf(1);",
+			nil,
+			false,
+			"Traceback (most recent call last):\n" +
+				"  script.mk:4:2 in <main>\n" +
+				"  script.mk:2:5 in f\n" +
+				"ERROR: script.mk:2:5: type mismatch: INTEGER + BOOLEAN\n",
+		},
+	}
+
+	for _, engine := range []string{EngineEval, EngineVM} {
+		for _, tt := range tests {
+			var errOut bytes.Buffer
+			streams := object.NewStreams(strings.NewReader(""), io.Discard, &errOut)
+			ok := Run("script.mk", tt.input, tt.args, streams, engine)
+			if ok != tt.expectedOk {
+				t.Errorf("[%s] wrong result for %q. expected=%t, got=%t (%s)",
+					engine, tt.input, tt.expectedOk, ok, errOut.String())
+			}
+			if errOut.String() != tt.expectedError {
+				t.Errorf("[%s] wrong errors for %q. expected=%q, got=%q",
+					engine, tt.input, tt.expectedError, errOut.String())
+			}
+		}
+	}
+}
+
+func TestRunStreams(t *testing.T) {
+	input := `let name = input("name? ");
+let age = readline();
+puts("hi " + name, age);
+print(1, "-", 2.5);
+eprint("oops");
+puts(readline());`
+
+	for _, engine := range []string{EngineEval, EngineVM} {
+		var out, errOut bytes.Buffer
+		streams := object.NewStreams(strings.NewReader("ana\r\n42"), &out, &errOut)
+		if !Run("script.mk", input, nil, streams, engine) {
+			t.Fatalf("[%s] unexpected error: %s", engine, errOut.String())
+		}
+		expected := "name? hi ana\n42\n1-2.5null\n"
+		if out.String() != expected {
+			t.Errorf("[%s] wrong output. expected=%q, got=%q", engine, expected, out.String())
+		}
+		if errOut.String() != "oops" {
+			t.Errorf("[%s] wrong errors. expected=%q, got=%q", engine, "oops", errOut.String())
+		}
+	}
+}
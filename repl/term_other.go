@@ -0,0 +1,29 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package repl
+
+import (
+	"errors"
+	"os"
+)
+
+// terminalState is the mode of a terminal. The line editor is not supported
+// in this system, so the REPL always reads plain lines.
+type terminalState struct{}
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func makeRaw(f *os.File) (*terminalState, error) {
+	return nil, errors.New("raw mode is not supported")
+}
+
+func restore(f *os.File, state *terminalState) error {
+	return nil
+}
+
+func terminalWidth(f *os.File) int {
+	return 80
+}
@@ -0,0 +1,80 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package repl
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalState is the mode of a terminal, saved to restore it after reading
+// a line in raw mode
+type terminalState struct {
+	termios syscall.Termios
+}
+
+// isTerminal reports whether the file is a terminal rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	_, err := getTermios(f.Fd())
+	return err == nil
+}
+
+// makeRaw puts the terminal in raw mode, where the keys are read one by one
+// without echo, and returns its previous mode
+func makeRaw(f *os.File) (*terminalState, error) {
+	termios, err := getTermios(f.Fd())
+	if err != nil {
+		return nil, err
+	}
+	state := &terminalState{termios: *termios}
+
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Oflag &^= syscall.OPOST
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	if err := setTermios(f.Fd(), termios); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// restore puts the terminal back in the mode returned by makeRaw
+func restore(f *os.File, state *terminalState) error {
+	return setTermios(f.Fd(), &state.termios)
+}
+
+// terminalWidth returns the number of columns of the terminal, or 80 if it's
+// unknown
+func terminalWidth(f *os.File) int {
+	var size struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 || size.Col == 0 {
+		return 80
+	}
+	return int(size.Col)
+}
+
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return &termios, nil
+}
+
+func setTermios(fd uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -1,11 +1,50 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
-	// TODO: Also read the column, line and filename to improve error messages
+	Pos     Position // where the token starts in the source code
+	// Comments found between the previous token and this one. They are only
+	// retained if the lexer is asked to, e.g. to format the code back.
+	Comments []Comment
+}
+
+// Comment is a `// line` or `/* block */` comment, including its delimiters
+type Comment struct {
+	Text string
+	Pos  Position
+}
+
+// Position points to a location in the source code. Line and Column start at 1
+// and Offset is the byte offset from the beginning of the input.
+type Position struct {
+	Filename string
+	Line     int
+	Column   int
+	Offset   int
+}
+
+// IsValid returns true if the position has been set, i.e. it comes from the lexer
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position like `script.mk:12:7`, or `12:7` if there is no filename
+func (p Position) String() string {
+	if !p.IsValid() {
+		return p.Filename
+	}
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
 }
 
 const (
@@ -15,6 +54,7 @@ const (
 	// Identifiers + literals
 	IDENT = "IDENT" // add, foobar, x, y
 	INT   = "INT"   // 123456
+	FLOAT = "FLOAT" // 1.5, .5, 1e-3
 
 	// Operators
 	ASSIGN   = "="
@@ -23,15 +63,37 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+	POWER    = "**"
 	LT       = "<"
 	GT       = ">"
+	LT_EQ    = "<="
+	GT_EQ    = ">="
 	EQ       = "=="
 	NOT_EQ   = "!="
+	AND      = "&&"
+	OR       = "||"
+
+	// Bitwise operators
+	AMPERSAND = "&"
+	PIPE      = "|"
+	CARET     = "^"
+	TILDE     = "~"
+	SHL       = "<<"
+	SHR       = ">>"
+
+	// Compound assignments, like `x += 1`
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
 
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	ELLIPSIS  = "..."
+	DOT       = "."
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -48,18 +110,46 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
 
 	STRING = "STRING"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"throw":    THROW,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+}
+
+// Keywords returns the keywords of the language, sorted
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // LookupIdent checks the keywords table to see whether the given identifier
@@ -0,0 +1,22 @@
+package vm
+
+import (
+	"github.com/juandspy/monkey-lang/code"
+	"github.com/juandspy/monkey-lang/object"
+)
+
+// Frame holds the execution state of a function call
+type Frame struct {
+	cl          *object.Closure
+	ip          int // instruction pointer inside this frame
+	basePointer int // value of the stack pointer before calling the function
+	numArgs     int // number of arguments passed, to know which default values are needed
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,920 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/juandspy/monkey-lang/code"
+	"github.com/juandspy/monkey-lang/compiler"
+	"github.com/juandspy/monkey-lang/object"
+)
+
+const (
+	// MaxFrames is the main frame and object.MaxCallDepth nested calls, so that
+	// a recursion overflows at the same depth as in the evaluator
+	MaxFrames = object.MaxCallDepth + 1
+	// StackSize leaves room for the arguments, locals and operands of 16 values
+	// per frame on average. Deep recursions of functions that need more
+	// overflow the stack before reaching MaxFrames.
+	StackSize   = 16 * MaxFrames
+	GlobalsSize = 65536
+)
+
+var (
+	Null  = &object.Null{}
+	True  = &object.Boolean{Value: true}
+	False = &object.Boolean{Value: false}
+)
+
+// VM executes the bytecode generated by the compiler
+type VM struct {
+	constants []object.Object
+	builtins  []object.Object // the builtins and namespaces of the compiler
+
+	stack []object.Object
+	sp    int // always points to the next free slot. Top of stack is stack[sp-1]
+
+	globals     []object.Object
+	globalNames []string // to report the globals read before being set
+
+	frames      []*Frame
+	framesIndex int
+
+	handlers []handler // handlers of the try blocks being run, the innermost last
+
+	execution *object.Execution // enforces the limits of the run
+	streams   *object.Streams   // used by the builtins, the ones of the process if nil
+
+	lastPopped object.Object // result of the last expression statement
+}
+
+// handler is registered by OpTry to catch the errors raised until OpEndTry
+type handler struct {
+	catch      int // offset of the instruction to jump to, in the frame of the try
+	frameIndex int // index of the frame of the try
+	sp         int // stack pointer when the try started
+}
+
+// New returns a VM ready to run the given bytecode
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants:   bytecode.Constants,
+		builtins:    bytecode.Builtins,
+		stack:       make([]object.Object, StackSize),
+		sp:          0,
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// NewWithGlobalsStore is like New but it reuses the globals of a previous run,
+// which is what the REPL needs between lines
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// LastPoppedStackElem returns the result of the program, i.e. the value of its
+// last expression statement. It's nil if the program ended with a let statement.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped
+}
+
+// Run executes the instructions. Runtime errors are returned as *object.Error
+// with the position of the instruction that caused them.
+func (vm *VM) Run() error {
+	return vm.RunContext(context.Background(), object.Limits{})
+}
+
+// SetStreams changes the standard input and outputs used by the builtins
+func (vm *VM) SetStreams(streams *object.Streams) {
+	vm.streams = streams
+}
+
+// Usage returns the resources used by the last run
+func (vm *VM) Usage() object.Usage {
+	if vm.execution == nil {
+		return object.Usage{}
+	}
+	return vm.execution.Usage()
+}
+
+// RunContext is like Run, but the execution stops with an error of kind
+// object.LimitError when ctx is done or the limits are exceeded. The steps are
+// the instructions run.
+func (vm *VM) RunContext(ctx context.Context, limits object.Limits) (err error) {
+	execution, cancel := object.NewExecution(ctx, limits)
+	defer cancel()
+	execution.SetStreams(vm.streams)
+	execution.SetRoots(vm.markRoots)
+	vm.execution = execution
+
+	var frame *Frame
+	var ip int
+	defer func() {
+		// a Go panic is a bug in the VM or in a builtin, but it must not crash
+		// the host process
+		if r := recover(); r != nil {
+			err = vm.withStackTrace(withPosition(newError("internal error: %v", r), frame, ip))
+		}
+	}()
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		frame = vm.currentFrame()
+		ip = frame.ip
+		ins := frame.Instructions()
+		op := code.Opcode(ins[ip])
+
+		if err := vm.execution.Step(); err != nil {
+			return vm.withStackTrace(withPosition(err, frame, ip))
+		}
+		halt, err := vm.execute(op, ins, ip)
+		if err != nil {
+			err = withPosition(err, frame, ip)
+			if !vm.catch(err) {
+				return vm.withStackTrace(err)
+			}
+			continue
+		}
+		if halt {
+			return nil
+		}
+	}
+	return nil
+}
+
+// markRoots marks the values the program can still reach: the globals, the
+// stack and the free variables of the closures being run
+func (vm *VM) markRoots(m *object.Marker) {
+	for _, global := range vm.globals {
+		m.Mark(global)
+	}
+	for _, obj := range vm.stack[:vm.sp] {
+		m.Mark(obj)
+	}
+	for _, frame := range vm.frames[:vm.framesIndex] {
+		m.Mark(frame.cl)
+	}
+	m.Mark(vm.lastPopped)
+}
+
+// execute runs a single instruction. It returns true if the program must stop,
+// i.e. there is a return statement at the top level.
+func (vm *VM) execute(op code.Opcode, ins code.Instructions, ip int) (bool, error) {
+	switch op {
+	case code.OpConstant:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		return false, vm.push(vm.constants[constIndex])
+
+	case code.OpPop:
+		vm.lastPopped = vm.pop()
+
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+		code.OpGreaterEqual, code.OpLessEqual, code.OpMod, code.OpPow,
+		code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
+		return false, vm.executeBinaryOperation(op)
+
+	case code.OpBang:
+		return false, vm.push(vm.executeBangOperator(vm.pop()))
+
+	case code.OpMinus:
+		return false, vm.executeMinusOperator()
+
+	case code.OpBitNot:
+		return false, vm.executeBitNotOperator()
+
+	case code.OpTrue:
+		return false, vm.push(True)
+	case code.OpFalse:
+		return false, vm.push(False)
+	case code.OpNull:
+		return false, vm.push(Null)
+
+	case code.OpJump:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		// the loop increments ip before reading, so we point to the previous one
+		vm.currentFrame().ip = pos - 1
+
+	case code.OpJumpNotTruthy:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		condition := vm.pop()
+		if !isTruthy(condition) {
+			vm.currentFrame().ip = pos - 1
+		}
+
+	case code.OpSetGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		vm.globals[globalIndex] = vm.pop()
+		// a program ending with a let statement has no result
+		vm.lastPopped = nil
+
+	case code.OpGetGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		global := vm.globals[globalIndex]
+		if global == nil {
+			// declared by the compiler, but its let didn't run or failed
+			return false, newError("identifier not found: %s", vm.globalNames[globalIndex])
+		}
+		return false, vm.push(global)
+
+	case code.OpSetLocal:
+		localIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+		frame := vm.currentFrame()
+		vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+	case code.OpGetLocal:
+		localIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+		frame := vm.currentFrame()
+		return false, vm.push(vm.stack[frame.basePointer+int(localIndex)])
+
+	case code.OpGetBuiltin:
+		builtinIndex := code.ReadUint16(ins[ip+1:])
+		vm.currentFrame().ip += 2
+		return false, vm.push(vm.builtins[builtinIndex])
+
+	case code.OpGetFree:
+		freeIndex := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+		currentClosure := vm.currentFrame().cl
+		return false, vm.push(currentClosure.Free[freeIndex])
+
+	case code.OpCurrentClosure:
+		return false, vm.push(vm.currentFrame().cl)
+
+	case code.OpArray:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		array := vm.buildArray(vm.sp-numElements, vm.sp)
+		vm.sp = vm.sp - numElements
+		return false, vm.pushAllocated(array)
+
+	case code.OpHash:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+		if err != nil {
+			return false, err
+		}
+		vm.sp = vm.sp - numElements
+		return false, vm.pushAllocated(hash)
+
+	case code.OpIndex:
+		index := vm.pop()
+		left := vm.pop()
+		return false, vm.executeIndexExpression(left, index)
+
+	case code.OpCall:
+		numArgs := code.ReadUint8(ins[ip+1:])
+		vm.currentFrame().ip += 1
+		return false, vm.executeCall(int(numArgs))
+
+	case code.OpReturnValue:
+		returnValue := vm.pop()
+		if vm.framesIndex == 1 {
+			// return statement at the top level, the program ends here
+			vm.lastPopped = returnValue
+			return true, nil
+		}
+		frame := vm.popFrame()
+		vm.sp = frame.basePointer - 1
+		return false, vm.push(returnValue)
+
+	case code.OpReturn:
+		frame := vm.popFrame()
+		vm.sp = frame.basePointer - 1
+		return false, vm.push(Null)
+
+	case code.OpClosure:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		numFree := code.ReadUint8(ins[ip+3:])
+		vm.currentFrame().ip += 3
+		return false, vm.pushClosure(int(constIndex), int(numFree))
+
+	case code.OpSkipDefault:
+		param := int(code.ReadUint8(ins[ip+1:]))
+		pos := int(code.ReadUint16(ins[ip+2:]))
+		vm.currentFrame().ip += 3
+		if param < vm.currentFrame().numArgs {
+			vm.currentFrame().ip = pos - 1
+		}
+
+	case code.OpIter:
+		iterable := vm.pop()
+		iterator, ok := object.NewIterator(iterable)
+		if !ok {
+			return false, newError("cannot iterate over %s", iterable.Type())
+		}
+		return false, vm.push(iterator)
+
+	case code.OpIterNext:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		iterator := vm.stack[vm.sp-1].(*object.Iterator)
+		element, ok := iterator.Next()
+		if !ok {
+			vm.pop()
+			vm.currentFrame().ip = pos - 1
+			return false, nil
+		}
+		return false, vm.push(element)
+
+	case code.OpSetIndex:
+		return false, vm.executeSetIndex()
+
+	case code.OpUpdateIndex:
+		operator := code.Opcode(code.ReadUint8(ins[ip+1:]))
+		vm.currentFrame().ip += 1
+		return false, vm.executeUpdateIndex(operator)
+
+	case code.OpNewCell:
+		return false, vm.push(&object.Cell{Value: vm.pop()})
+
+	case code.OpLoadCell:
+		cell := vm.pop().(*object.Cell)
+		return false, vm.push(cell.Value)
+
+	case code.OpStoreCell:
+		cell := vm.pop().(*object.Cell)
+		cell.Value = vm.pop()
+
+	case code.OpTry:
+		pos := int(code.ReadUint16(ins[ip+1:]))
+		vm.currentFrame().ip += 2
+		vm.handlers = append(vm.handlers, handler{catch: pos, frameIndex: vm.framesIndex - 1, sp: vm.sp})
+
+	case code.OpEndTry:
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	case code.OpCatch:
+		err := vm.pop().(*object.Error)
+		return false, vm.push(err.Value())
+
+	case code.OpThrow:
+		return false, object.NewThrownError(vm.pop())
+
+	default:
+		def, err := code.Lookup(byte(op))
+		if err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("opcode %s not implemented", def.Name)
+	}
+	return false, nil
+}
+
+// executeSetIndex assigns the element of an array or hash
+func (vm *VM) executeSetIndex() error {
+	value := vm.pop()
+	index := vm.pop()
+	left := vm.pop()
+	// like let statements, index assignments have no result
+	vm.lastPopped = nil
+
+	if err := object.SetIndex(left, index, value); err != nil {
+		return err
+	}
+	return nil
+}
+
+// executeUpdateIndex runs compound assignments like `arr[0] += 1`, applying the
+// operator to the current element and the value before assigning the result
+func (vm *VM) executeUpdateIndex(operator code.Opcode) error {
+	value := vm.pop()
+	index := vm.pop()
+	left := vm.pop()
+	vm.lastPopped = nil
+
+	if err := vm.executeIndexExpression(left, index); err != nil {
+		return err
+	}
+	if err := vm.push(value); err != nil {
+		return err
+	}
+	if err := vm.executeBinaryOperation(operator); err != nil {
+		return err
+	}
+	if err := object.SetIndex(left, index, vm.pop()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return newError("stack overflow")
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+	return nil
+}
+
+// pushAllocated pushes a new string, array or hash, counting its memory
+// towards the limit of the execution
+func (vm *VM) pushAllocated(o object.Object) error {
+	if err := vm.execution.Allocate(o); err != nil {
+		return err
+	}
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return newError("stack overflow")
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// operators maps the opcodes to the operators used in the error messages, so
+// that they are the same as the evaluator ones
+var operators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpGreaterEqual: ">=",
+	code.OpLessEqual:    "<=",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+	leftType := left.Type()
+	rightType := right.Type()
+
+	switch {
+	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
+		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		// at least one of them is a float, so the integer is converted to float
+		return vm.executeBinaryFloatOperation(op, left, right)
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+		return vm.executeBinaryStringOperation(op, left, right)
+	case op == code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(left == right))
+	case op == code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(left != right))
+	case leftType != rightType:
+		return newError("type mismatch: %s %s %s", leftType, operators[op], rightType)
+	default:
+		return newError("unknown operator: %s %s %s", leftType, operators[op], rightType)
+	}
+}
+
+func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	switch op {
+	case code.OpAdd:
+		return vm.push(&object.Integer{Value: leftValue + rightValue})
+	case code.OpSub:
+		return vm.push(&object.Integer{Value: leftValue - rightValue})
+	case code.OpMul:
+		return vm.push(&object.Integer{Value: leftValue * rightValue})
+	case code.OpDiv:
+		if rightValue == 0 {
+			return newError("division by zero")
+		}
+		return vm.push(&object.Integer{Value: leftValue / rightValue})
+	case code.OpMod:
+		if rightValue == 0 {
+			return newError("division by zero")
+		}
+		return vm.push(&object.Integer{Value: leftValue % rightValue})
+	case code.OpPow:
+		return vm.push(powInteger(leftValue, rightValue))
+	case code.OpBitAnd:
+		return vm.push(&object.Integer{Value: leftValue & rightValue})
+	case code.OpBitOr:
+		return vm.push(&object.Integer{Value: leftValue | rightValue})
+	case code.OpBitXor:
+		return vm.push(&object.Integer{Value: leftValue ^ rightValue})
+	case code.OpShiftLeft, code.OpShiftRight:
+		if rightValue < 0 {
+			return newError("negative shift count: %d", rightValue)
+		}
+		if op == code.OpShiftLeft {
+			return vm.push(&object.Integer{Value: leftValue << uint64(rightValue)})
+		}
+		return vm.push(&object.Integer{Value: leftValue >> uint64(rightValue)})
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+}
+
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	switch op {
+	case code.OpAdd:
+		return vm.push(&object.Float{Value: leftValue + rightValue})
+	case code.OpSub:
+		return vm.push(&object.Float{Value: leftValue - rightValue})
+	case code.OpMul:
+		return vm.push(&object.Float{Value: leftValue * rightValue})
+	case code.OpDiv:
+		if rightValue == 0 {
+			return newError("division by zero")
+		}
+		return vm.push(&object.Float{Value: leftValue / rightValue})
+	case code.OpMod:
+		if rightValue == 0 {
+			return newError("division by zero")
+		}
+		return vm.push(&object.Float{Value: math.Mod(leftValue, rightValue)})
+	case code.OpPow:
+		return vm.push(&object.Float{Value: math.Pow(leftValue, rightValue)})
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+}
+
+// executeBinaryStringOperation concatenates strings with `+` and compares them
+// lexicographically, byte by byte
+func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpAdd:
+		return vm.pushAllocated(&object.String{Value: leftValue + rightValue})
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue <= rightValue))
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operators[op], right.Type())
+	}
+}
+
+// powInteger returns base ** exp. A negative exponent gives a float, as in
+// `2 ** -1 == 0.5`.
+func powInteger(base, exp int64) object.Object {
+	if exp < 0 {
+		return &object.Float{Value: math.Pow(float64(base), float64(exp))}
+	}
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return &object.Integer{Value: result}
+}
+
+func (vm *VM) executeBangOperator(operand object.Object) object.Object {
+	switch operand {
+	case True:
+		return False
+	case False:
+		return True
+	case Null:
+		return True
+	default:
+		return False
+	}
+}
+
+func (vm *VM) executeMinusOperator() error {
+	switch operand := vm.pop().(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
+		return newError("unknown operator: -%s", operand.Type())
+	}
+}
+
+func (vm *VM) executeBitNotOperator() error {
+	operand := vm.pop()
+	integer, ok := operand.(*object.Integer)
+	if !ok {
+		return newError("unknown operator: ~%s", operand.Type())
+	}
+	return vm.push(&object.Integer{Value: ^integer.Value})
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+		hashedPairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	default:
+		return newError("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	i := index.(*object.Integer).Value
+	max := int64(len(arrayObject.Elements) - 1)
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+	return vm.push(arrayObject.Elements[i])
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return vm.push(Null)
+	}
+	return vm.push(pair.Value)
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	fn := cl.Fn
+	if err := object.CheckArguments(fn.NumParameters, fn.NumDefaults, fn.Variadic, numArgs); err != nil {
+		return err
+	}
+	// the main frame is not a call, so the depth of the new one is the number of frames
+	if err := vm.execution.CheckDepth(vm.framesIndex); err != nil {
+		return err
+	}
+	// the arguments are already in the stack and become the first locals
+	frame := NewFrame(cl, vm.sp-numArgs)
+	frame.numArgs = numArgs
+	if frame.basePointer+fn.NumLocals >= StackSize {
+		return newError("stack overflow")
+	}
+	var rest *object.Array
+	if fn.Variadic {
+		rest = &object.Array{Elements: []object.Object{}}
+		if numArgs > fn.NumParameters {
+			rest.Elements = append(rest.Elements, vm.stack[frame.basePointer+fn.NumParameters:vm.sp]...)
+		}
+		if err := vm.execution.Allocate(rest); err != nil {
+			return err
+		}
+	}
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+
+	// the missing arguments are set by the default values code, but a default
+	// value could refer to a later parameter
+	for i := numArgs; i < fn.NumParameters; i++ {
+		vm.stack[frame.basePointer+i] = Null
+	}
+	if fn.Variadic {
+		vm.stack[frame.basePointer+fn.NumParameters] = rest
+	}
+	vm.sp = frame.basePointer + fn.NumLocals
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+	result := builtin.Fn(vm.execution, args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if err, ok := result.(*object.Error); ok {
+		// errors are propagated like the evaluator does
+		return err
+	}
+	if result == nil {
+		return vm.push(Null)
+	}
+	if builtin.Allocates {
+		return vm.pushAllocated(result)
+	}
+	return vm.push(result)
+}
+
+func (vm *VM) pushClosure(constIndex, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+// isNumber returns true for integers and floats
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat returns the value of an integer or float object as a float64
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// withPosition sets the position of the instruction that failed to the error,
+// unless it already points to a more specific place
+func withPosition(err error, frame *Frame, ip int) error {
+	objErr, ok := err.(*object.Error)
+	if !ok || objErr.Pos.IsValid() {
+		return err
+	}
+	objErr.Pos = frame.cl.Fn.Positions[ip]
+	return objErr
+}
+
+// catch passes the error to the innermost handler, if any, unless it's an error
+// of the limits of the execution. The frames above the
+// one of the try are discarded and the error is pushed for the catch code.
+func (vm *VM) catch(err error) bool {
+	objErr, ok := err.(*object.Error)
+	if !ok || objErr.Kind == object.LimitError || len(vm.handlers) == 0 {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.addStackFrames(objErr, h.frameIndex)
+	vm.framesIndex = h.frameIndex + 1
+	vm.sp = h.sp
+	vm.currentFrame().ip = h.catch - 1
+	return vm.push(objErr) == nil
+}
+
+// withStackTrace adds the function calls in progress to the stack trace of the
+// error, the innermost first, like the evaluator does when the error propagates
+func (vm *VM) withStackTrace(err error) error {
+	objErr, ok := err.(*object.Error)
+	if !ok {
+		return err
+	}
+	vm.addStackFrames(objErr, 0)
+	return objErr
+}
+
+// addStackFrames adds the calls of the frames above the given one to the stack
+// trace of the error. Those frames are left by the error, so a rethrown error
+// keeps the frames it already had.
+func (vm *VM) addStackFrames(objErr *object.Error, frameIndex int) {
+	for i := vm.framesIndex - 1; i > frameIndex; i-- {
+		caller := vm.frames[i-1]
+		objErr.Stack = append(objErr.Stack, object.StackFrame{
+			Function: vm.frames[i].cl.Fn.Name,
+			// the ip of the caller points to the operand of OpCall
+			Pos: caller.cl.Fn.Positions[caller.ip-1],
+		})
+	}
+}
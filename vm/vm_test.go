@@ -0,0 +1,162 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/juandspy/monkey-lang/ast"
+	"github.com/juandspy/monkey-lang/compiler"
+	"github.com/juandspy/monkey-lang/internal/enginetest"
+	"github.com/juandspy/monkey-lang/lexer"
+	"github.com/juandspy/monkey-lang/object"
+	"github.com/juandspy/monkey-lang/parser"
+)
+
+// TestEngine runs the cases shared with the evaluator, so that both engines
+// behave the same way
+func TestEngine(t *testing.T) {
+	enginetest.Run(t, func(
+		ctx context.Context, program *ast.Program, limits object.Limits,
+	) (object.Object, object.Usage, error) {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			return nil, object.Usage{}, err
+		}
+		vm := New(comp.Bytecode())
+		err := vm.RunContext(ctx, limits)
+		return vm.LastPoppedStackElem(), vm.Usage(), err
+	})
+}
+
+func TestRecoverFromPanic(t *testing.T) {
+	program := parser.New(lexer.NewFile("script.mk", "let x = 1;\nx + boom()")).ParseProgram()
+	comp := compiler.New()
+	boom := comp.SymbolTable().Define("boom")
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	globals := make([]object.Object, GlobalsSize)
+	globals[boom.Index] = &object.Builtin{Fn: func(execution *object.Execution, args ...object.Object) object.Object {
+		panic("something went wrong")
+	}}
+
+	err = NewWithGlobalsStore(comp.Bytecode(), globals).Run()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected := "script.mk:2:9: internal error: something went wrong"
+	if err.Error() != expected {
+		t.Errorf("wrong error. expected=%q, got=%q", expected, err.Error())
+	}
+}
+
+func TestBuiltinsRegistry(t *testing.T) {
+	builtins := object.NewRegistry()
+	builtins.Remove("puts")
+	builtins.Register("strings.double", &object.Builtin{MinArgs: 1, MaxArgs: 1, Fn: func(execution *object.Execution, args ...object.Object) object.Object {
+		return &object.String{Value: strings.Repeat(args[0].(*object.String).Value, 2)}
+	}})
+
+	comp := compiler.NewWithBuiltins(builtins)
+	if err := comp.Compile(parse(`strings.double("ab") + strings.join(["c"], "")`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	enginetest.Check(t, "strings.double", "ababc", vm.LastPoppedStackElem(), nil)
+
+	comp = compiler.NewWithBuiltins(builtins)
+	if err := comp.Compile(parse(`puts("hi")`)); err == nil || !strings.Contains(err.Error(), "puts") {
+		t.Errorf("expected an error compiling a removed builtin, got=%v", err)
+	}
+}
+
+// TestManyBuiltins checks that the index of a builtin can take more than a byte
+func TestManyBuiltins(t *testing.T) {
+	builtins := object.NewEmptyRegistry()
+	for i := 0; i < 300; i++ {
+		value := int64(i)
+		builtins.Register(fmt.Sprintf("b%c%c", 'a'+i/26, 'a'+i%26), &object.Builtin{Fn: func(execution *object.Execution, args ...object.Object) object.Object {
+			return &object.Integer{Value: value}
+		}})
+	}
+
+	comp := compiler.NewWithBuiltins(builtins)
+	if err := comp.Compile(parse("blm()")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	enginetest.Check(t, "blm()", 11*26+12, vm.LastPoppedStackElem(), nil)
+}
+
+// TestCompilerLimits checks that the values that don't fit in the operands of
+// the instructions are compilation errors instead of being cut off
+func TestCompilerLimits(t *testing.T) {
+	// the identifiers can't have digits, so the locals are named xaa, xab...
+	locals := func(n int) string {
+		var out strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&out, "let x%c%c = 1; ", 'a'+i/26, 'a'+i%26)
+		}
+		return out.String()
+	}
+	constants := func(n int) string {
+		var out strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&out, "x = x + %d; ", i)
+		}
+		return out.String()
+	}
+	tests := []vmTestCase{
+		{"fn() { " + locals(300) + "}", enginetest.Error("too many local bindings: 256")},
+		{"let f = fn(...xs) { len(xs) }; f(" + strings.Repeat("1, ", 299) + "1)", enginetest.Error("too many arguments: 300")},
+		{"let x = 0; " + constants(70000), enginetest.Error("too many constants: 65536")},
+		{"let x = 0; [" + strings.Repeat("x, ", 69999) + "x]", enginetest.Error("too many elements in an array literal: 70000")},
+		{"let x = 0; if (true) { " + strings.Repeat("x = x + x; ", 7000) + "}", enginetest.Error("too many instructions to jump over: 70014")},
+		// the largest values that fit
+		{"let f = fn() { " + locals(256) + "xjv }; f()", 1},
+		{"let f = fn(...xs) { len(xs) }; f(" + strings.Repeat("1, ", 254) + "1)", 255},
+	}
+	runVmTests(t, tests)
+}
+
+func parse(input string) *ast.Program {
+	return parseFile("", input)
+}
+
+func parseFile(filename, input string) *ast.Program {
+	l := lexer.NewFile(filename, input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+// vmTestCase is a case that only the VM runs. The expected results are the ones
+// of enginetest.Case.
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		var result object.Object
+		if err == nil {
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+			result = vm.LastPoppedStackElem()
+		}
+		enginetest.Check(t, tt.input, tt.expected, result, err)
+	}
+}